@@ -0,0 +1,127 @@
+package macaroon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	armorBegin = "-----BEGIN MACAROON SLICE-----"
+	armorEnd   = "-----END MACAROON SLICE-----"
+
+	// armorLineLen holds the maximum length of
+	// a line of base64 data in the armored format.
+	armorLineLen = 64
+
+	// armorChecksumLen holds the number of bytes of
+	// the SHA-256 hash of the data used as a checksum.
+	armorChecksumLen = 6
+)
+
+// EncodeArmor returns the armored text form of the macaroons
+// in s, suitable for pasting into emails, tickets and chat.
+// The form is similar to PEM: the binary encoding of the slice
+// as produced by Slice.MarshalBinary is encoded as standard
+// base64 wrapped at 64 columns, followed by a checksum line
+// holding "=" and the base64-encoded first 6 bytes of the
+// SHA-256 hash of the binary data, all between
+// "-----BEGIN MACAROON SLICE-----" and
+// "-----END MACAROON SLICE-----" lines. For example:
+//
+//	-----BEGIN MACAROON SLICE-----
+//	AgETaHR0cDovL2V4YW1wbGUuY29tLwIHc29tZSBpZAAABiDZFs5vm2LcSggM5dSm
+//	YJVkcfGbhg2kJCsIUnJzMcEDPQ==
+//	=mk3D9tTx
+//	-----END MACAROON SLICE-----
+func EncodeArmor(s Slice) ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(text, data)
+	var buf bytes.Buffer
+	buf.WriteString(armorBegin)
+	buf.WriteByte('\n')
+	for len(text) > 0 {
+		n := armorLineLen
+		if n > len(text) {
+			n = len(text)
+		}
+		buf.Write(text[0:n])
+		buf.WriteByte('\n')
+		text = text[n:]
+	}
+	buf.WriteByte('=')
+	buf.WriteString(armorChecksum(data))
+	buf.WriteByte('\n')
+	buf.WriteString(armorEnd)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// DecodeArmor decodes the armored macaroons in data,
+// as produced by EncodeArmor. Any text before the begin line
+// or after the end line is ignored, as are blank lines and
+// leading and trailing white space on each line. Truncation and corruption are
+// detected by checking for the end line and the checksum
+// before the macaroons are unmarshaled.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
+func DecodeArmor(data []byte) (Slice, error) {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimSpace(line)
+	}
+	start := -1
+	for i, line := range lines {
+		if string(line) == armorBegin {
+			start = i + 1
+			break
+		}
+	}
+	if start == -1 {
+		return nil, fmt.Errorf("no armored macaroons found")
+	}
+	lines = lines[start:]
+	var text []byte
+	checksum := ""
+	for i, line := range lines {
+		switch {
+		case string(line) == armorEnd:
+			if checksum == "" {
+				return nil, fmt.Errorf("armored macaroons have no checksum")
+			}
+			payload, err := base64.StdEncoding.DecodeString(string(text))
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode armored macaroons: %v", err)
+			}
+			if armorChecksum(payload) != checksum {
+				return nil, fmt.Errorf("armored macaroons have invalid checksum")
+			}
+			var s Slice
+			if err := DefaultUnmarshalOptions.parseSlice(&s, payload); err != nil {
+				return nil, err
+			}
+			return s, nil
+		case len(line) == 0:
+			continue
+		case checksum != "":
+			return nil, fmt.Errorf("unexpected data after checksum on line %d", start+i+1)
+		case len(line) > 0 && line[0] == '=':
+			checksum = string(line[1:])
+		default:
+			text = append(text, line...)
+		}
+	}
+	return nil, fmt.Errorf("armored macaroons are truncated (no end line found)")
+}
+
+// armorChecksum returns the checksum of data
+// as used in the armored format.
+func armorChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[0:armorChecksumLen])
+}
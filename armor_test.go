@@ -0,0 +1,134 @@
+package macaroon_test
+
+import (
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type armorSuite struct{}
+
+var _ = gc.Suite(&armorSuite{})
+
+const armoredExample = `-----BEGIN MACAROON SLICE-----
+AgETaHR0cDovL2V4YW1wbGUuY29tLwIHc29tZSBpZAAABiDZFs5vm2LcSggM5dSm
+YJVkcfGbhg2kJCsIUnJzMcEDPQ==
+=mk3D9tTx
+-----END MACAROON SLICE-----
+`
+
+func armorTestSlice(c *gc.C) macaroon.Slice {
+	m := MustNew([]byte("secret"), []byte("some id"), "http://example.com/", macaroon.V2)
+	return macaroon.Slice{m}
+}
+
+func (*armorSuite) TestEncodeArmor(c *gc.C) {
+	data, err := macaroon.EncodeArmor(armorTestSlice(c))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, armoredExample)
+}
+
+func (*armorSuite) TestArmorRoundTrip(c *gc.C) {
+	m0 := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	err := m0.AddFirstPartyCaveat(strings.Repeat("a long caveat ", 20))
+	c.Assert(err, gc.IsNil)
+	m1 := MustNew([]byte("another secret"), []byte("another id"), "", macaroon.V2)
+	s := macaroon.Slice{m0, m1}
+
+	data, err := macaroon.EncodeArmor(s)
+	c.Assert(err, gc.IsNil)
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines {
+		c.Assert(len(line) <= 64, gc.Equals, true)
+	}
+	s1, err := macaroon.DecodeArmor(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, jc.DeepEquals, s)
+
+	// Surrounding text, indentation and CRLF line
+	// endings are tolerated.
+	pasted := "Here's the macaroon:\r\n\r\n"
+	for _, line := range lines {
+		pasted += "    " + line + "\r\n"
+	}
+	pasted += "Thanks.\r\n"
+	s1, err = macaroon.DecodeArmor([]byte(pasted))
+	c.Assert(err, gc.IsNil)
+	c.Assert(s1, jc.DeepEquals, s)
+}
+
+var decodeArmorErrorTests = []struct {
+	about       string
+	edit        func(string) string
+	expectError string
+}{{
+	about: "no begin line",
+	edit: func(s string) string {
+		return s[strings.Index(s, "\n")+1:]
+	},
+	expectError: `no armored macaroons found`,
+}, {
+	about: "truncated data",
+	edit: func(s string) string {
+		return s[0:40]
+	},
+	expectError: `armored macaroons are truncated \(no end line found\)`,
+}, {
+	about: "no end line",
+	edit: func(s string) string {
+		return strings.Replace(s, "-----END MACAROON SLICE-----\n", "", 1)
+	},
+	expectError: `armored macaroons are truncated \(no end line found\)`,
+}, {
+	about: "missing line",
+	edit: func(s string) string {
+		lines := strings.Split(s, "\n")
+		return strings.Join(append(lines[0:1], lines[2:]...), "\n")
+	},
+	expectError: `armored macaroons have invalid checksum`,
+}, {
+	about: "corrupted character",
+	edit: func(s string) string {
+		i := strings.Index(s, "\n") + 10
+		c := byte('A')
+		if s[i] == 'A' {
+			c = 'B'
+		}
+		return s[0:i] + string(c) + s[i+1:]
+	},
+	expectError: `armored macaroons have invalid checksum`,
+}, {
+	about: "invalid base64",
+	edit: func(s string) string {
+		i := strings.Index(s, "\n") + 10
+		return s[0:i] + "!" + s[i+1:]
+	},
+	expectError: `cannot decode armored macaroons: illegal base64 data at input byte 9`,
+}, {
+	about: "no checksum",
+	edit: func(s string) string {
+		lines := strings.Split(s, "\n")
+		return strings.Join(append(lines[0:len(lines)-3], lines[len(lines)-2:]...), "\n")
+	},
+	expectError: `armored macaroons have no checksum`,
+}, {
+	about: "data after checksum",
+	edit: func(s string) string {
+		return strings.Replace(s, "-----END", "AAAA\n-----END", 1)
+	},
+	expectError: `unexpected data after checksum on line 5`,
+}}
+
+func (*armorSuite) TestDecodeArmorError(c *gc.C) {
+	data, err := macaroon.EncodeArmor(armorTestSlice(c))
+	c.Assert(err, gc.IsNil)
+	for i, test := range decodeArmorErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		s, err := macaroon.DecodeArmor([]byte(test.edit(string(data))))
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+		c.Assert(s, gc.IsNil)
+	}
+}
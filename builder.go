@@ -0,0 +1,225 @@
+package macaroon
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// Immutable holds a macaroon that cannot be changed. Unlike
+// *Macaroon, it may be freely shared between goroutines and
+// stored in caches without cloning, because none of its methods
+// modify it and all its accessors return copies.
+//
+// Immutable values are created with Builder.Build or Freeze;
+// the zero value holds no macaroon and its methods will panic.
+type Immutable struct {
+	m *Macaroon
+}
+
+// Freeze returns an immutable copy of m. Subsequent changes
+// to m do not affect the returned value.
+func Freeze(m *Macaroon) Immutable {
+	return Immutable{m.DeepClone()}
+}
+
+// Location returns the macaroon's location hint.
+func (v Immutable) Location() string {
+	return v.m.location
+}
+
+// Id returns a copy of the macaroon's identifier.
+func (v Immutable) Id() []byte {
+	return v.m.Id()
+}
+
+// Signature returns a copy of the macaroon's signature.
+func (v Immutable) Signature() []byte {
+	return v.m.Signature()
+}
+
+// Version returns the macaroon's version.
+func (v Immutable) Version() Version {
+	return v.m.version
+}
+
+// Caveats returns a copy of the macaroon's caveats.
+func (v Immutable) Caveats() []Caveat {
+	return v.m.DeepClone().caveats
+}
+
+// Extra returns a copy of any fields in the macaroon's
+// header that are not understood by this package.
+func (v Immutable) Extra() []Field {
+	return v.m.DeepClone().extra
+}
+
+// Macaroon returns a mutable copy of the macaroon.
+func (v Immutable) Macaroon() *Macaroon {
+	return v.m.DeepClone()
+}
+
+// Builder returns a Builder that can be used to attenuate
+// the macaroon by adding caveats to it.
+func (v Immutable) Builder() Builder {
+	return Builder{m: v.m}
+}
+
+// Verify is like Macaroon.Verify.
+func (v Immutable) Verify(rootKey []byte, check func(caveat string) error, discharges []Immutable) error {
+	return v.m.Verify(rootKey, check, macaroons(discharges))
+}
+
+// VerifyBytes is like Macaroon.VerifyBytes except that
+// check is passed a copy of each caveat condition, so
+// it cannot modify v or any of the discharges.
+func (v Immutable) VerifyBytes(rootKey []byte, check func(caveat []byte) error, discharges []Immutable) error {
+	return v.m.VerifyBytes(rootKey, func(caveat []byte) error {
+		return check(append([]byte(nil), caveat...))
+	}, macaroons(discharges))
+}
+
+// macaroons returns the macaroons held in vs.
+// The caller must not modify them.
+func macaroons(vs []Immutable) []*Macaroon {
+	ms := make([]*Macaroon, len(vs))
+	for i, v := range vs {
+		ms[i] = v.m
+	}
+	return ms
+}
+
+// Equal reports whether v and v1 hold the same
+// macaroon, as compared by Macaroon.Equal.
+func (v Immutable) Equal(v1 Immutable) bool {
+	return v.m.Equal(v1.m)
+}
+
+// Digest returns the macaroon's digest as
+// returned by Macaroon.Digest.
+func (v Immutable) Digest() [sha256.Size]byte {
+	return v.m.Digest()
+}
+
+// SignedDigest returns the macaroon's signed digest as
+// returned by Macaroon.SignedDigest.
+func (v Immutable) SignedDigest() [sha256.Size]byte {
+	return v.m.SignedDigest()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v Immutable) MarshalBinary() ([]byte, error) {
+	return v.m.MarshalBinary()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Immutable) MarshalJSON() ([]byte, error) {
+	return v.m.MarshalJSON()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (v Immutable) MarshalText() ([]byte, error) {
+	return v.m.MarshalText()
+}
+
+// String returns the macaroon in the format
+// returned by Macaroon.Inspect.
+func (v Immutable) String() string {
+	return v.m.Inspect()
+}
+
+// Builder creates immutable macaroons. Each of its methods
+// returns a new Builder, leaving the original unchanged, so
+// a Builder may be used as the starting point for several
+// different macaroons.
+//
+// Any error encountered is returned by Build; once
+// an error has occurred, subsequent operations have no effect.
+type Builder struct {
+	// m holds the macaroon built so far. It
+	// is never changed once the Builder has
+	// been created.
+	m   *Macaroon
+	err error
+}
+
+// NewBuilder returns a Builder for a new macaroon with
+// the given root key, identifier, location and version,
+// as created by New.
+func NewBuilder(rootKey, id []byte, loc string, version Version) Builder {
+	m, err := New(rootKey, id, loc, version)
+	return Builder{
+		m:   m,
+		err: err,
+	}
+}
+
+// AddFirstPartyCaveat returns a Builder that adds
+// a first party caveat as Macaroon.AddFirstPartyCaveat does.
+func (b Builder) AddFirstPartyCaveat(condition string) Builder {
+	return b.update(func(m *Macaroon) error {
+		return m.AddFirstPartyCaveat(condition)
+	})
+}
+
+// AddFirstPartyCaveatBytes returns a Builder that adds a first
+// party caveat as Macaroon.AddFirstPartyCaveatBytes does.
+func (b Builder) AddFirstPartyCaveatBytes(condition []byte) Builder {
+	return b.update(func(m *Macaroon) error {
+		return m.AddFirstPartyCaveatBytes(condition)
+	})
+}
+
+// AddThirdPartyCaveat returns a Builder that adds
+// a third party caveat as Macaroon.AddThirdPartyCaveat does.
+func (b Builder) AddThirdPartyCaveat(rootKey, caveatId []byte, loc string) Builder {
+	return b.update(func(m *Macaroon) error {
+		return m.AddThirdPartyCaveat(rootKey, caveatId, loc)
+	})
+}
+
+// AddThirdPartyCaveatWithRand returns a Builder that adds a third
+// party caveat as Macaroon.AddThirdPartyCaveatWithRand does.
+// See the security note there.
+func (b Builder) AddThirdPartyCaveatWithRand(rootKey, caveatId []byte, loc string, r io.Reader) Builder {
+	return b.update(func(m *Macaroon) error {
+		return m.AddThirdPartyCaveatWithRand(rootKey, caveatId, loc, r)
+	})
+}
+
+// Bind returns a Builder that binds the macaroon to
+// the macaroon with the given signature, as Macaroon.Bind
+// does.
+func (b Builder) Bind(sig []byte) Builder {
+	return b.update(func(m *Macaroon) error {
+		m.Bind(sig)
+		return nil
+	})
+}
+
+// Build returns the macaroon that has been built.
+func (b Builder) Build() (Immutable, error) {
+	if b.err != nil {
+		return Immutable{}, b.err
+	}
+	if b.m == nil {
+		return Immutable{}, fmt.Errorf("no macaroon in builder")
+	}
+	return Immutable{b.m}, nil
+}
+
+// update returns a Builder holding the result of
+// calling f on a copy of the builder's macaroon.
+func (b Builder) update(f func(m *Macaroon) error) Builder {
+	if b.err != nil || b.m == nil {
+		return b
+	}
+	// Clone ensures that appending caveats will not
+	// affect the original. The caveat data itself
+	// is never changed so it can be shared.
+	m := b.m.Clone()
+	if err := f(m); err != nil {
+		return Builder{err: err}
+	}
+	return Builder{m: m}
+}
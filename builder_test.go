@@ -0,0 +1,209 @@
+package macaroon_test
+
+import (
+	"fmt"
+	"sync"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type builderSuite struct{}
+
+var _ = gc.Suite(&builderSuite{})
+
+func (*builderSuite) TestBuild(c *gc.C) {
+	rootKey := []byte("secret")
+	v, err := macaroon.NewBuilder(rootKey, []byte("some id"), "a location", macaroon.V2).
+		AddFirstPartyCaveat("a caveat").
+		AddFirstPartyCaveat("another caveat").
+		Build()
+	c.Assert(err, gc.IsNil)
+	c.Assert(v.Location(), gc.Equals, "a location")
+	c.Assert(string(v.Id()), gc.Equals, "some id")
+	c.Assert(v.Version(), gc.Equals, macaroon.V2)
+	c.Assert(v.Caveats(), jc.DeepEquals, []macaroon.Caveat{{
+		Id: []byte("a caveat"),
+	}, {
+		Id: []byte("another caveat"),
+	}})
+
+	// The result is the same as building the
+	// macaroon in the usual way.
+	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.V2)
+	err = m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(v.Macaroon(), jc.DeepEquals, m)
+	c.Assert(v.Equal(macaroon.Freeze(m)), gc.Equals, true)
+
+	err = v.Verify(rootKey, func(string) error { return nil }, nil)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*builderSuite) TestBuilderDoesNotChange(c *gc.C) {
+	b := macaroon.NewBuilder([]byte("secret"), []byte("some id"), "", macaroon.V2).
+		AddFirstPartyCaveat("a caveat")
+	v0, err := b.Build()
+	c.Assert(err, gc.IsNil)
+
+	// Attenuating the builder in two different ways
+	// leaves the original unchanged.
+	v1, err := b.AddFirstPartyCaveat("caveat 1").Build()
+	c.Assert(err, gc.IsNil)
+	v2, err := b.AddFirstPartyCaveat("caveat 2").Build()
+	c.Assert(err, gc.IsNil)
+	v3, err := b.Build()
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(v0.Caveats(), gc.HasLen, 1)
+	c.Assert(v3.Equal(v0), gc.Equals, true)
+	c.Assert(string(v1.Caveats()[1].Id), gc.Equals, "caveat 1")
+	c.Assert(string(v2.Caveats()[1].Id), gc.Equals, "caveat 2")
+
+	// Likewise attenuating an immutable macaroon.
+	v4, err := v0.Builder().AddFirstPartyCaveat("caveat 4").Build()
+	c.Assert(err, gc.IsNil)
+	c.Assert(v0.Caveats(), gc.HasLen, 1)
+	c.Assert(v4.Caveats(), gc.HasLen, 2)
+	c.Assert(v4.Signature(), gc.Not(jc.DeepEquals), v0.Signature())
+}
+
+func (*builderSuite) TestAccessorsReturnCopies(c *gc.C) {
+	v, err := macaroon.NewBuilder([]byte("secret"), []byte("some id"), "", macaroon.V2).
+		AddFirstPartyCaveat("a caveat").
+		Build()
+	c.Assert(err, gc.IsNil)
+	v.Id()[0] = 'x'
+	v.Signature()[0] ^= 1
+	v.Caveats()[0].Id[0] = 'x'
+	v.Macaroon().AddFirstPartyCaveat("another caveat")
+
+	c.Assert(string(v.Id()), gc.Equals, "some id")
+	c.Assert(string(v.Caveats()[0].Id), gc.Equals, "a caveat")
+	c.Assert(v.Caveats(), gc.HasLen, 1)
+	err = v.Verify([]byte("secret"), func(string) error { return nil }, nil)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*builderSuite) TestFreezeCopies(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	v := macaroon.Freeze(m)
+	m.Caveats()[0].Id[0] = 'x'
+	err = m.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(v.Caveats(), jc.DeepEquals, []macaroon.Caveat{{
+		Id: []byte("a caveat"),
+	}})
+}
+
+func (*builderSuite) TestBuildCopiesArguments(c *gc.C) {
+	id := []byte("some id")
+	cid := []byte("third-party-id")
+	cond := []byte("a caveat")
+	v, err := macaroon.NewBuilder([]byte("secret"), id, "", macaroon.V2).
+		AddFirstPartyCaveatBytes(cond).
+		AddThirdPartyCaveat([]byte("3rd party key"), cid, "remote.com").
+		Build()
+	c.Assert(err, gc.IsNil)
+	sig := v.Signature()
+	id[0] = 'X'
+	cid[0] = 'X'
+	cond[0] = 'X'
+	c.Assert(string(v.Id()), gc.Equals, "some id")
+	c.Assert(string(v.Caveats()[0].Id), gc.Equals, "a caveat")
+	c.Assert(string(v.Caveats()[1].Id), gc.Equals, "third-party-id")
+	c.Assert(v.Signature(), jc.DeepEquals, sig)
+}
+
+func (*builderSuite) TestVerifyBytesPassesCopies(c *gc.C) {
+	rootKey := []byte("secret")
+	tpKey := []byte("3rd party key")
+	v, err := macaroon.NewBuilder(rootKey, []byte("some id"), "", macaroon.V2).
+		AddFirstPartyCaveat("abc").
+		AddThirdPartyCaveat(tpKey, []byte("3rd party caveat"), "remote.com").
+		Build()
+	c.Assert(err, gc.IsNil)
+	d, err := macaroon.NewBuilder(tpKey, []byte("3rd party caveat"), "remote.com", macaroon.V2).
+		AddFirstPartyCaveat("def").
+		Bind(v.Signature()).
+		Build()
+	c.Assert(err, gc.IsNil)
+
+	// Modifying the conditions passed to the check
+	// function must not change the macaroons.
+	err = v.VerifyBytes(rootKey, func(cav []byte) error {
+		cav[0] = 'X'
+		return nil
+	}, []macaroon.Immutable{d})
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(v.Caveats()[0].Id), gc.Equals, "abc")
+	c.Assert(string(d.Caveats()[0].Id), gc.Equals, "def")
+	err = v.VerifyBytes(rootKey, func([]byte) error { return nil }, []macaroon.Immutable{d})
+	c.Assert(err, gc.IsNil)
+}
+
+func (*builderSuite) TestBuildError(c *gc.C) {
+	_, err := macaroon.NewBuilder([]byte("secret"), []byte("some id"), "", macaroon.V1).
+		AddFirstPartyCaveat("\xff").
+		AddFirstPartyCaveat("a caveat").
+		Build()
+	c.Assert(err, gc.ErrorMatches, `first party caveat condition is not a valid utf-8 string`)
+
+	_, err = macaroon.NewBuilder([]byte("secret"), []byte("some id"), "", 99).Build()
+	c.Assert(err, gc.ErrorMatches, `invalid version v99`)
+
+	_, err = macaroon.Builder{}.AddFirstPartyCaveat("a caveat").Build()
+	c.Assert(err, gc.ErrorMatches, `no macaroon in builder`)
+}
+
+func (*builderSuite) TestThirdPartyAndDischarge(c *gc.C) {
+	rootKey := []byte("secret")
+	tpKey := []byte("3rd party key")
+	v, err := macaroon.NewBuilder(rootKey, []byte("some id"), "", macaroon.V2).
+		AddThirdPartyCaveat(tpKey, []byte("3rd party caveat"), "remote.com").
+		Build()
+	c.Assert(err, gc.IsNil)
+	d, err := macaroon.NewBuilder(tpKey, []byte("3rd party caveat"), "remote.com", macaroon.V2).
+		AddFirstPartyCaveat("discharge caveat").
+		Bind(v.Signature()).
+		Build()
+	c.Assert(err, gc.IsNil)
+
+	var checked []string
+	err = v.Verify(rootKey, func(cav string) error {
+		checked = append(checked, cav)
+		return nil
+	}, []macaroon.Immutable{d})
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, jc.DeepEquals, []string{"discharge caveat"})
+}
+
+func (*builderSuite) TestConcurrentUse(c *gc.C) {
+	v, err := macaroon.NewBuilder([]byte("secret"), []byte("some id"), "", macaroon.V2).
+		AddFirstPartyCaveat("a caveat").
+		Build()
+	c.Assert(err, gc.IsNil)
+	var wg sync.WaitGroup
+	results := make([]macaroon.Immutable, 10)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = v.Builder().AddFirstPartyCaveat(fmt.Sprint(i)).Build()
+			v.Caveats()
+			v.MarshalBinary()
+		}()
+	}
+	wg.Wait()
+	c.Assert(v.Caveats(), gc.HasLen, 1)
+	for i, r := range results {
+		c.Assert(string(r.Caveats()[1].Id), gc.Equals, fmt.Sprint(i))
+	}
+}
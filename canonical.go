@@ -0,0 +1,96 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Canonical returns the canonical binary encoding of the
+// binary-encoded macaroon in data, in the same format version.
+// Any encoding accepted by Macaroon.UnmarshalBinary is
+// accepted, but data must hold exactly one macaroon.
+//
+// Two encodings of the same macaroon always have the same
+// canonical form, so the result is suitable for comparing or
+// hashing encoded macaroons. It is the same as the result of
+// Macaroon.MarshalBinary on the unmarshaled macaroon, and
+// is accepted by UnmarshalOptions with RequireCanonical set.
+func Canonical(data []byte) ([]byte, error) {
+	var m Macaroon
+	rest, err := m.parseBinary(data, &DefaultUnmarshalOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected data after macaroon")
+	}
+	return m.MarshalBinary()
+}
+
+// checkCanonical checks that data, which holds the
+// encoded form of m, is the canonical encoding of m.
+func (m *Macaroon) checkCanonical(data []byte) error {
+	if m.version == V2 {
+		// Check the common cases first so that
+		// we can give a more informative error.
+		if err := checkCanonicalV2(data); err != nil {
+			return fmt.Errorf("non-canonical %v encoding: %v", V2, err)
+		}
+	}
+	data1, err := m.appendBinary(nil)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, data1) {
+		return fmt.Errorf("non-canonical %v encoding", m.version)
+	}
+	return nil
+}
+
+// checkCanonicalV2 checks the packets in the V2-encoded
+// macaroon held in data for non-minimal varints and
+// empty optional fields. It assumes that data has already
+// been successfully parsed.
+func checkCanonicalV2(data []byte) error {
+	// Skip the version byte.
+	data = data[1:]
+	for len(data) > 0 {
+		rest, ft, err := parseCanonicalVarint(data)
+		if err != nil {
+			return err
+		}
+		if fieldType(ft) == fieldEOS {
+			data = rest
+			continue
+		}
+		rest, n, err := parseCanonicalVarint(rest)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			switch fieldType(ft) {
+			case fieldLocation:
+				return fmt.Errorf("empty location field")
+			case fieldVerificationId:
+				return fmt.Errorf("empty verification id field")
+			}
+		}
+		data = rest[n:]
+	}
+	return nil
+}
+
+// parseCanonicalVarint is like parseVarint except that
+// it returns an error if the varint is not minimally encoded.
+func parseCanonicalVarint(data []byte) ([]byte, int, error) {
+	rest, x, err := parseVarint(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	if n := binary.PutUvarint(buf[:], uint64(x)); n != len(data)-len(rest) {
+		return nil, 0, fmt.Errorf("non-minimal varint encoding")
+	}
+	return rest, x, nil
+}
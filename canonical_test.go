@@ -0,0 +1,113 @@
+package macaroon_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type canonicalSuite struct{}
+
+var _ = gc.Suite(&canonicalSuite{})
+
+var canonicalTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	about: "canonical",
+	data:  "\x02" + "\x02\x02id" + "\x00" + "\x02\x02c1" + "\x00" + "\x00",
+}, {
+	about:       "non-minimal field type",
+	data:        "\x02" + "\x82\x00\x02id" + "\x00" + "\x02\x02c1" + "\x00" + "\x00",
+	expectError: `non-canonical v2 encoding: non-minimal varint encoding`,
+}, {
+	about:       "non-minimal field length",
+	data:        "\x02" + "\x02\x82\x00id" + "\x00" + "\x02\x02c1" + "\x00" + "\x00",
+	expectError: `non-canonical v2 encoding: non-minimal varint encoding`,
+}, {
+	about:       "empty location",
+	data:        "\x02" + "\x01\x00" + "\x02\x02id" + "\x00" + "\x02\x02c1" + "\x00" + "\x00",
+	expectError: `non-canonical v2 encoding: empty location field`,
+}, {
+	about:       "empty verification id",
+	data:        "\x02" + "\x02\x02id" + "\x00" + "\x02\x02c1" + "\x04\x00" + "\x00" + "\x00",
+	expectError: `non-canonical v2 encoding: empty verification id field`,
+}}
+
+func (*canonicalSuite) TestRequireCanonical(c *gc.C) {
+	sig := "\x06\x20" + string(make([]byte, 32))
+	canonical := canonicalTests[0].data + sig
+	opts := macaroon.UnmarshalOptions{
+		RequireCanonical: true,
+	}
+	for i, test := range canonicalTests {
+		c.Logf("test %d: %s", i, test.about)
+		data := []byte(test.data + sig)
+
+		// Non-canonical data is accepted by default.
+		var m0 macaroon.Macaroon
+		err := m0.UnmarshalBinary(data)
+		c.Assert(err, gc.IsNil)
+
+		var m macaroon.Macaroon
+		err = opts.UnmarshalMacaroonBinary(&m, data)
+		var s macaroon.Slice
+		serr := opts.UnmarshalSliceBinary(&s, data)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(serr, gc.ErrorMatches, "cannot unmarshal macaroon: "+test.expectError)
+		} else {
+			c.Assert(err, gc.IsNil)
+			c.Assert(serr, gc.IsNil)
+			c.Assert(s, jc.DeepEquals, macaroon.Slice{&m})
+		}
+		c.Assert(&m0, jc.DeepEquals, &m)
+
+		// All the encodings have the same canonical form.
+		cdata, err := macaroon.Canonical(data)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(cdata), gc.Equals, canonical)
+	}
+}
+
+func (*canonicalSuite) TestRequireCanonicalTrailingData(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "loc", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	data = append(data, 0)
+
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+
+	opts := macaroon.UnmarshalOptions{
+		RequireCanonical: true,
+	}
+	err = opts.UnmarshalMacaroonBinary(&m1, data)
+	c.Assert(err, gc.ErrorMatches, `unexpected data after macaroon`)
+
+	_, err = macaroon.Canonical(data)
+	c.Assert(err, gc.ErrorMatches, `unexpected data after macaroon`)
+}
+
+func (*canonicalSuite) TestRequireCanonicalV1(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "loc", macaroon.V1)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	opts := macaroon.UnmarshalOptions{
+		RequireCanonical: true,
+	}
+	var m1 macaroon.Macaroon
+	err = opts.UnmarshalMacaroonBinary(&m1, data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m1, jc.DeepEquals, m)
+
+	cdata, err := macaroon.Canonical(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(cdata, jc.DeepEquals, data)
+}
@@ -0,0 +1,612 @@
+package macaroon
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// The CBOR encoding (RFC 8949) of a macaroon is a map with small
+// integer keys:
+//
+//	0: version (unsigned integer)
+//	1: location (string, omitted if empty)
+//	2: identifier (byte string)
+//	3: caveats (array of caveat maps, omitted if empty)
+//	5: extra fields (array of [type, byte string] arrays, omitted if empty)
+//	6: signature (byte string)
+//
+// Each caveat is encoded as a map:
+//
+//	1: location (string, omitted if empty)
+//	2: identifier (byte string)
+//	4: verification id (byte string, omitted if empty)
+//	5: extra fields (as above, omitted if empty)
+//
+// Locations are encoded as text strings when they are valid
+// UTF-8 and as byte strings otherwise, which is possible for
+// V2 macaroons.
+//
+// A Slice is encoded as an array of macaroon maps.
+//
+// The encoding produced is always in the core deterministic
+// form described by RFC 8949 section 4.2.1. When decoding,
+// indefinite-length items, tags, unknown keys
+// and duplicate keys are rejected.
+const (
+	cborKeyVersion        = 0
+	cborKeyLocation       = 1
+	cborKeyIdentifier     = 2
+	cborKeyCaveats        = 3
+	cborKeyVerificationId = 4
+	cborKeyExtra          = 5
+	cborKeySignature      = 6
+)
+
+// CBOR major types.
+const (
+	cborUint   = 0
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+// MarshalCBOR returns the CBOR encoding of the macaroon.
+func (m *Macaroon) MarshalCBOR() ([]byte, error) {
+	return m.appendCBOR(nil)
+}
+
+// UnmarshalCBOR unmarshals the CBOR-encoded macaroon in data
+// into m, as produced by MarshalCBOR.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
+func (m *Macaroon) UnmarshalCBOR(data []byte) error {
+	return DefaultUnmarshalOptions.UnmarshalMacaroonCBOR(m, data)
+}
+
+// MarshalCBOR returns the CBOR encoding of the slice
+// as an array of macaroons.
+func (s Slice) MarshalCBOR() ([]byte, error) {
+	data := appendCBORHead(nil, cborArray, uint64(len(s)))
+	var err error
+	for i, m := range s {
+		if m == nil {
+			return nil, fmt.Errorf("nil macaroon at index %d", i)
+		}
+		data, err = m.appendCBOR(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal macaroon %q: %v", m.Id(), err)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalCBOR unmarshals the CBOR-encoded slice in data
+// into s, as produced by Slice.MarshalCBOR.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
+func (s *Slice) UnmarshalCBOR(data []byte) error {
+	return DefaultUnmarshalOptions.UnmarshalSliceCBOR(s, data)
+}
+
+// UnmarshalMacaroonCBOR unmarshals the CBOR-encoded macaroon
+// in data into m, as Macaroon.UnmarshalCBOR does, but
+// enforcing the limits in o.
+func (o *UnmarshalOptions) UnmarshalMacaroonCBOR(m *Macaroon, data []byte) error {
+	if err := o.checkSize(len(data)); err != nil {
+		return err
+	}
+	// Copy the data to avoid retaining references to it
+	// in the internal data structures.
+	d := &cborDecoder{
+		data: append([]byte(nil), data...),
+		opts: o,
+	}
+	if err := d.macaroon(m); err != nil {
+		return cborError(err)
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("cannot unmarshal CBOR macaroon: unexpected data after macaroon")
+	}
+	return o.checkMacaroon(m)
+}
+
+// UnmarshalSliceCBOR unmarshals the CBOR-encoded macaroons
+// in data into s, as Slice.UnmarshalCBOR does, but
+// enforcing the limits in o.
+func (o *UnmarshalOptions) UnmarshalSliceCBOR(s *Slice, data []byte) error {
+	if err := o.checkSize(len(data)); err != nil {
+		return err
+	}
+	d := &cborDecoder{
+		data: append([]byte(nil), data...),
+		opts: o,
+	}
+	n, err := d.arrayHead()
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal CBOR macaroons: %v", err)
+	}
+	if err := o.checkSliceLen(n); err != nil {
+		return err
+	}
+	ms := make(Slice, n)
+	for i := range ms {
+		var m Macaroon
+		if err := d.macaroon(&m); err != nil {
+			return cborError(err)
+		}
+		if err := o.checkMacaroon(&m); err != nil {
+			return err
+		}
+		ms[i] = &m
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("cannot unmarshal CBOR macaroons: unexpected data after macaroons")
+	}
+	*s = ms
+	return nil
+}
+
+// cborError returns the error to return when decoding
+// a CBOR macaroon fails with the given error.
+func cborError(err error) error {
+	if _, ok := err.(*LimitError); ok {
+		return err
+	}
+	return fmt.Errorf("cannot unmarshal CBOR macaroon: %v", err)
+}
+
+// appendCBOR appends the CBOR encoding of m to data.
+func (m *Macaroon) appendCBOR(data []byte) ([]byte, error) {
+	if m.version < V1 || m.version > LatestVersion {
+		return nil, fmt.Errorf("bad macaroon version %v", m.version)
+	}
+	n := 3
+	if m.location != "" {
+		n++
+	}
+	if len(m.caveats) > 0 {
+		n++
+	}
+	if len(m.extra) > 0 {
+		n++
+	}
+	data = appendCBORHead(data, cborMap, uint64(n))
+	data = appendCBORHead(data, cborUint, cborKeyVersion)
+	data = appendCBORHead(data, cborUint, uint64(m.version))
+	if m.location != "" {
+		data = appendCBORHead(data, cborUint, cborKeyLocation)
+		data = appendLocationCBOR(data, m.location)
+	}
+	data = appendCBORHead(data, cborUint, cborKeyIdentifier)
+	data = appendCBORString(data, cborBytes, m.id)
+	if len(m.caveats) > 0 {
+		data = appendCBORHead(data, cborUint, cborKeyCaveats)
+		data = appendCBORHead(data, cborArray, uint64(len(m.caveats)))
+		for _, cav := range m.caveats {
+			data = appendCaveatCBOR(data, cav)
+		}
+	}
+	if len(m.extra) > 0 {
+		data = appendCBORHead(data, cborUint, cborKeyExtra)
+		data = appendExtraCBOR(data, m.extra)
+	}
+	data = appendCBORHead(data, cborUint, cborKeySignature)
+	data = appendCBORString(data, cborBytes, m.sig[:])
+	return data, nil
+}
+
+// appendCaveatCBOR appends the CBOR encoding of cav to data.
+func appendCaveatCBOR(data []byte, cav Caveat) []byte {
+	n := 1
+	if cav.Location != "" {
+		n++
+	}
+	if len(cav.VerificationId) > 0 {
+		n++
+	}
+	if len(cav.Extra) > 0 {
+		n++
+	}
+	data = appendCBORHead(data, cborMap, uint64(n))
+	if cav.Location != "" {
+		data = appendCBORHead(data, cborUint, cborKeyLocation)
+		data = appendLocationCBOR(data, cav.Location)
+	}
+	data = appendCBORHead(data, cborUint, cborKeyIdentifier)
+	data = appendCBORString(data, cborBytes, cav.Id)
+	if len(cav.VerificationId) > 0 {
+		data = appendCBORHead(data, cborUint, cborKeyVerificationId)
+		data = appendCBORString(data, cborBytes, cav.VerificationId)
+	}
+	if len(cav.Extra) > 0 {
+		data = appendCBORHead(data, cborUint, cborKeyExtra)
+		data = appendExtraCBOR(data, cav.Extra)
+	}
+	return data
+}
+
+// appendExtraCBOR appends the CBOR encoding of the
+// given extra fields to data.
+func appendExtraCBOR(data []byte, fields []Field) []byte {
+	data = appendCBORHead(data, cborArray, uint64(len(fields)))
+	for _, f := range fields {
+		data = appendCBORHead(data, cborArray, 2)
+		data = appendCBORHead(data, cborUint, uint64(f.Type))
+		data = appendCBORString(data, cborBytes, f.Data)
+	}
+	return data
+}
+
+// appendLocationCBOR appends the CBOR encoding of
+// the location loc to data.
+func appendLocationCBOR(data []byte, loc string) []byte {
+	if utf8.ValidString(loc) {
+		return appendCBORString(data, cborText, []byte(loc))
+	}
+	return appendCBORString(data, cborBytes, []byte(loc))
+}
+
+// appendCBORString appends a CBOR byte or text
+// string holding s to data.
+func appendCBORString(data []byte, major byte, s []byte) []byte {
+	data = appendCBORHead(data, major, uint64(len(s)))
+	return append(data, s...)
+}
+
+// appendCBORHead appends the initial bytes of a
+// CBOR data item with the given major type and
+// argument to data, using the shortest possible form.
+func appendCBORHead(data []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(data, major|byte(n))
+	case n <= 0xff:
+		return append(data, major|24, byte(n))
+	case n <= 0xffff:
+		return append(data, major|25, byte(n>>8), byte(n))
+	case n <= 0xffffffff:
+		return append(data, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(data, major|27,
+		byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n),
+	)
+}
+
+// cborDecoder decodes CBOR-encoded macaroons. It
+// retains references to the data being decoded.
+type cborDecoder struct {
+	// data holds the data remaining to be decoded.
+	data []byte
+
+	// opts holds the limits to enforce. The caveat
+	// limit is checked before the caveats are decoded.
+	opts *UnmarshalOptions
+}
+
+// macaroon decodes a macaroon into m.
+func (d *cborDecoder) macaroon(m *Macaroon) error {
+	n, err := d.mapHead()
+	if err != nil {
+		return err
+	}
+	var (
+		vers    Version
+		loc     string
+		id      []byte
+		caveats []Caveat
+		extra   []Field
+		sig     []byte
+	)
+	seen := make(map[uint64]bool)
+	for i := 0; i < n; i++ {
+		key, err := d.key(seen)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case cborKeyVersion:
+			v, err := d.uint()
+			if err != nil {
+				return fmt.Errorf("invalid version: %v", err)
+			}
+			if v < uint64(V1) || v > uint64(LatestVersion) {
+				return fmt.Errorf("unknown version %d", v)
+			}
+			vers = Version(v)
+		case cborKeyLocation:
+			loc, err = d.location()
+			if err != nil {
+				return fmt.Errorf("invalid location: %v", err)
+			}
+		case cborKeyIdentifier:
+			id, err = d.bytes()
+			if err != nil {
+				return fmt.Errorf("invalid identifier: %v", err)
+			}
+		case cborKeyCaveats:
+			ncav, err := d.arrayHead()
+			if err != nil {
+				return fmt.Errorf("invalid caveats: %v", err)
+			}
+			if err := d.opts.checkCaveatCount(ncav); err != nil {
+				return err
+			}
+			caveats = make([]Caveat, ncav)
+			for j := range caveats {
+				if err := d.caveat(&caveats[j]); err != nil {
+					return fmt.Errorf("invalid caveat %d: %v", j, err)
+				}
+			}
+		case cborKeyExtra:
+			extra, err = d.extra()
+			if err != nil {
+				return fmt.Errorf("invalid extra fields: %v", err)
+			}
+		case cborKeySignature:
+			sig, err = d.bytes()
+			if err != nil {
+				return fmt.Errorf("invalid signature: %v", err)
+			}
+			if len(sig) != hashLen {
+				return fmt.Errorf("signature has unexpected length %d", len(sig))
+			}
+		default:
+			return fmt.Errorf("unknown macaroon key %d", key)
+		}
+	}
+	switch {
+	case vers == 0:
+		return fmt.Errorf("no version found")
+	case id == nil:
+		return fmt.Errorf("no identifier found")
+	case sig == nil:
+		return fmt.Errorf("no signature found")
+	}
+	m.init(id, loc, vers)
+	m.caveats = caveats
+	m.extra = extra
+	copy(m.sig[:], sig)
+	if vers == V1 {
+		return m.checkV1()
+	}
+	return nil
+}
+
+// caveat decodes a caveat into cav.
+func (d *cborDecoder) caveat(cav *Caveat) error {
+	n, err := d.mapHead()
+	if err != nil {
+		return err
+	}
+	seen := make(map[uint64]bool)
+	for i := 0; i < n; i++ {
+		key, err := d.key(seen)
+		if err != nil {
+			return err
+		}
+		switch key {
+		case cborKeyLocation:
+			cav.Location, err = d.location()
+		case cborKeyIdentifier:
+			cav.Id, err = d.bytes()
+		case cborKeyVerificationId:
+			cav.VerificationId, err = d.bytes()
+		case cborKeyExtra:
+			cav.Extra, err = d.extra()
+		default:
+			return fmt.Errorf("unknown caveat key %d", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if cav.Id == nil {
+		return fmt.Errorf("no identifier found")
+	}
+	if len(cav.VerificationId) == 0 {
+		cav.VerificationId = nil
+		if cav.Location != "" {
+			return fmt.Errorf("location not allowed in first party caveat")
+		}
+	}
+	return nil
+}
+
+// extra decodes a list of extra fields.
+func (d *cborDecoder) extra() ([]Field, error) {
+	n, err := d.arrayHead()
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]Field, n)
+	prevType := 0
+	for i := range fields {
+		if n, err := d.arrayHead(); err != nil {
+			return nil, err
+		} else if n != 2 {
+			return nil, fmt.Errorf("extra field has %d elements, not 2", n)
+		}
+		t, err := d.uint()
+		if err != nil {
+			return nil, err
+		}
+		if t > 0x7fffffff || isKnownFieldTypeV2(fieldType(t)) || int(t) <= prevType {
+			return nil, fmt.Errorf("invalid extra field type %d", t)
+		}
+		data, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = Field{
+			Type: int(t),
+			Data: data,
+		}
+		prevType = int(t)
+	}
+	return fields, nil
+}
+
+// key decodes a map key, checking that it
+// has not been seen before.
+func (d *cborDecoder) key(seen map[uint64]bool) (uint64, error) {
+	key, err := d.uint()
+	if err != nil {
+		return 0, fmt.Errorf("invalid key: %v", err)
+	}
+	if seen[key] {
+		return 0, fmt.Errorf("duplicate key %d", key)
+	}
+	seen[key] = true
+	return key, nil
+}
+
+// uint decodes an unsigned integer.
+func (d *cborDecoder) uint() (uint64, error) {
+	return d.head(cborUint)
+}
+
+// bytes decodes a byte string.
+func (d *cborDecoder) bytes() ([]byte, error) {
+	return d.string(cborBytes)
+}
+
+// text decodes a text string.
+func (d *cborDecoder) text() (string, error) {
+	s, err := d.string(cborText)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(s) {
+		return "", fmt.Errorf("text string is not valid UTF-8")
+	}
+	return string(s), nil
+}
+
+// location decodes a location, which must be a text
+// string unless it is not valid UTF-8, in which case
+// it must be a byte string.
+func (d *cborDecoder) location() (string, error) {
+	if len(d.data) == 0 || d.data[0]>>5 != cborBytes {
+		return d.text()
+	}
+	s, err := d.bytes()
+	if err != nil {
+		return "", err
+	}
+	if utf8.Valid(s) {
+		return "", fmt.Errorf("valid UTF-8 encoded as byte string")
+	}
+	return string(s), nil
+}
+
+// string decodes a byte or text string with the given
+// major type.
+func (d *cborDecoder) string(major byte) ([]byte, error) {
+	n, err := d.head(major)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)) {
+		return nil, fmt.Errorf("string extends past end of buffer")
+	}
+	s := d.data[0:n:n]
+	d.data = d.data[n:]
+	return s, nil
+}
+
+// arrayHead decodes the start of an array
+// and returns the number of elements.
+func (d *cborDecoder) arrayHead() (int, error) {
+	return d.count(cborArray)
+}
+
+// mapHead decodes the start of a map
+// and returns the number of entries.
+func (d *cborDecoder) mapHead() (int, error) {
+	return d.count(cborMap)
+}
+
+// count decodes the start of an array or map
+// and returns the number of items.
+func (d *cborDecoder) count(major byte) (int, error) {
+	n, err := d.head(major)
+	if err != nil {
+		return 0, err
+	}
+	// Every item takes at least one byte, so this
+	// guards against allocating huge amounts of
+	// memory for hostile input.
+	if n > uint64(len(d.data)) {
+		return 0, fmt.Errorf("too many items for data length")
+	}
+	return int(n), nil
+}
+
+// head decodes the initial bytes of a data item,
+// which must be of the given major type,
+// and returns its argument.
+func (d *cborDecoder) head(major byte) (uint64, error) {
+	if len(d.data) == 0 {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+	b := d.data[0]
+	if b>>5 != major {
+		return 0, fmt.Errorf("unexpected %s, expected %s", cborTypeName(b>>5), cborTypeName(major))
+	}
+	info := b & 0x1f
+	d.data = d.data[1:]
+	if info < 24 {
+		return uint64(info), nil
+	}
+	var size int
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	case 31:
+		return 0, fmt.Errorf("indefinite-length %s not supported", cborTypeName(major))
+	default:
+		return 0, fmt.Errorf("invalid additional information %d", info)
+	}
+	if len(d.data) < size {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+	var n uint64
+	for _, b := range d.data[0:size] {
+		n = n<<8 | uint64(b)
+	}
+	d.data = d.data[size:]
+	return n, nil
+}
+
+func cborTypeName(major byte) string {
+	switch major {
+	case cborUint:
+		return "unsigned integer"
+	case 1:
+		return "negative integer"
+	case cborBytes:
+		return "byte string"
+	case cborText:
+		return "text string"
+	case cborArray:
+		return "array"
+	case cborMap:
+		return "map"
+	case cborTag:
+		return "tag"
+	case cborSimple:
+		return "simple value"
+	}
+	panic("unreachable")
+}
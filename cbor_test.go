@@ -0,0 +1,248 @@
+package macaroon_test
+
+import (
+	"encoding/hex"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type cborSuite struct{}
+
+var _ = gc.Suite(&cborSuite{})
+
+func (*cborSuite) TestMarshalCBOR(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("id"), "", macaroon.V2)
+	err := m.AddFirstPartyCaveat("c1")
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	c.Assert(hex.EncodeToString(data), gc.Equals, ""+
+		"a4"+ // map(4)
+		"0002"+ // version: 2
+		"02426964"+ // identifier: h'6964'
+		"0381"+"a1"+"02426331"+ // caveats: [{identifier: h'6331'}]
+		"065820"+hex.EncodeToString(m.Signature()), // signature
+	)
+}
+
+func (*cborSuite) TestCBORRoundTrip(c *gc.C) {
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		c.Logf("version %v", vers)
+		m := MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		err := m.AddFirstPartyCaveat("a caveat")
+		c.Assert(err, gc.IsNil)
+		err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+		c.Assert(err, gc.IsNil)
+
+		data, err := m.MarshalCBOR()
+		c.Assert(err, gc.IsNil)
+		var m1 macaroon.Macaroon
+		err = m1.UnmarshalCBOR(data)
+		c.Assert(err, gc.IsNil)
+		c.Assert(&m1, jc.DeepEquals, m)
+
+		// Check that the result is the same as
+		// the other encodings.
+		binData, err := m.MarshalBinary()
+		c.Assert(err, gc.IsNil)
+		binData1, err := m1.MarshalBinary()
+		c.Assert(err, gc.IsNil)
+		c.Assert(binData1, jc.DeepEquals, binData)
+
+		jsonData, err := m.MarshalJSON()
+		c.Assert(err, gc.IsNil)
+		jsonData1, err := m1.MarshalJSON()
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(jsonData1), gc.Equals, string(jsonData))
+
+		var m2 macaroon.Macaroon
+		err = m2.UnmarshalBinary(binData)
+		c.Assert(err, gc.IsNil)
+		data2, err := m2.MarshalCBOR()
+		c.Assert(err, gc.IsNil)
+		c.Assert(data2, jc.DeepEquals, data)
+	}
+}
+
+func (*cborSuite) TestCBORRoundTripExtraFields(c *gc.C) {
+	var m macaroon.Macaroon
+	err := m.UnmarshalBinary([]byte(unknownFieldsV2 + "\x06\x20" + string(make([]byte, 32))))
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalCBOR(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m1, jc.DeepEquals, &m)
+}
+
+func (*cborSuite) TestCBORRoundTripNonUTF8Location(c *gc.C) {
+	// V2 macaroons may have locations that are not valid UTF-8.
+	var m macaroon.Macaroon
+	err := m.UnmarshalBinary([]byte("\x02" +
+		"\x01\x02\xff\xfe" + "\x02\x02id" + "\x00" +
+		"\x01\x02\xfd\xfc" + "\x02\x02c1" + "\x04\x01v" + "\x00" +
+		"\x00" +
+		"\x06\x20" + string(make([]byte, 32)),
+	))
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Location(), gc.Equals, "\xff\xfe")
+	data, err := m.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalCBOR(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m1, jc.DeepEquals, &m)
+	c.Assert(m1.Caveats()[0].Location, gc.Equals, "\xfd\xfc")
+}
+
+func (*cborSuite) TestSliceCBORRoundTrip(c *gc.C) {
+	m0 := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	m1 := MustNew([]byte("another secret"), []byte("another id"), "", macaroon.V2)
+	ms := macaroon.Slice{m0, m1}
+	data, err := ms.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	var ms1 macaroon.Slice
+	err = ms1.UnmarshalCBOR(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms1, jc.DeepEquals, ms)
+
+	data, err = macaroon.Slice{}.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, jc.DeepEquals, []byte{0x80})
+	err = ms1.UnmarshalCBOR(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms1, gc.HasLen, 0)
+}
+
+var sig32 = "5820" + hex.EncodeToString(make([]byte, 32))
+
+var unmarshalCBORErrorTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	about:       "empty data",
+	data:        "",
+	expectError: `cannot unmarshal CBOR macaroon: unexpected end of data`,
+}, {
+	about:       "not a map",
+	data:        "80",
+	expectError: `cannot unmarshal CBOR macaroon: unexpected array, expected map`,
+}, {
+	about:       "indefinite-length map",
+	data:        "bf",
+	expectError: `cannot unmarshal CBOR macaroon: indefinite-length map not supported`,
+}, {
+	about:       "unknown key",
+	data:        "a1" + "07" + "00",
+	expectError: `cannot unmarshal CBOR macaroon: unknown macaroon key 7`,
+}, {
+	about:       "duplicate key",
+	data:        "a2" + "0002" + "0002",
+	expectError: `cannot unmarshal CBOR macaroon: duplicate key 0`,
+}, {
+	about:       "no version",
+	data:        "a2" + "02426964" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: no version found`,
+}, {
+	about:       "unknown version",
+	data:        "a3" + "0003" + "02426964" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: unknown version 3`,
+}, {
+	about:       "no identifier",
+	data:        "a2" + "0002" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: no identifier found`,
+}, {
+	about:       "no signature",
+	data:        "a2" + "0002" + "02426964",
+	expectError: `cannot unmarshal CBOR macaroon: no signature found`,
+}, {
+	about:       "short signature",
+	data:        "a3" + "0002" + "02426964" + "064100",
+	expectError: `cannot unmarshal CBOR macaroon: signature has unexpected length 1`,
+}, {
+	about:       "identifier as text",
+	data:        "a3" + "0002" + "02626964" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: invalid identifier: unexpected text string, expected byte string`,
+}, {
+	about:       "invalid UTF-8 location",
+	data:        "a4" + "0002" + "0161ff" + "02426964" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: invalid location: text string is not valid UTF-8`,
+}, {
+	about:       "valid UTF-8 location as byte string",
+	data:        "a4" + "0002" + "014161" + "02426964" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: invalid location: valid UTF-8 encoded as byte string`,
+}, {
+	about:       "location in first party caveat",
+	data:        "a4" + "0002" + "02426964" + "0381" + "a2" + "016161" + "02426331" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: invalid caveat 0: location not allowed in first party caveat`,
+}, {
+	about:       "string too long",
+	data:        "a2" + "0002" + "0245",
+	expectError: `cannot unmarshal CBOR macaroon: invalid identifier: string extends past end of buffer`,
+}, {
+	about:       "huge array",
+	data:        "a3" + "0002" + "02426964" + "039bffffffffffffffff",
+	expectError: `cannot unmarshal CBOR macaroon: invalid caveats: too many items for data length`,
+}, {
+	about:       "trailing data",
+	data:        "a3" + "0002" + "02426964" + "06" + sig32 + "00",
+	expectError: `cannot unmarshal CBOR macaroon: unexpected data after macaroon`,
+}, {
+	about:       "non-UTF-8 id in V1",
+	data:        "a3" + "0001" + "0241ff" + "06" + sig32,
+	expectError: `cannot unmarshal CBOR macaroon: macaroon id is not valid UTF-8`,
+}}
+
+func (*cborSuite) TestUnmarshalCBORError(c *gc.C) {
+	for i, test := range unmarshalCBORErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		data, err := hex.DecodeString(test.data)
+		c.Assert(err, gc.IsNil)
+		var m macaroon.Macaroon
+		err = m.UnmarshalCBOR(data)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (*cborSuite) TestUnmarshalCBORLimits(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	for _, cav := range []string{"a", "b", "c"} {
+		err := m.AddFirstPartyCaveat(cav)
+		c.Assert(err, gc.IsNil)
+	}
+	data, err := m.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	opts := macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	}
+	var m1 macaroon.Macaroon
+	err = opts.UnmarshalMacaroonCBOR(&m1, data)
+	checkLimitError(c, err, "MaxCaveats", `too many caveats \(limit 2\)`)
+
+	data, err = macaroon.Slice{m, m, m}.MarshalCBOR()
+	c.Assert(err, gc.IsNil)
+	opts = macaroon.UnmarshalOptions{
+		MaxSliceLen: 2,
+	}
+	var s macaroon.Slice
+	err = opts.UnmarshalSliceCBOR(&s, data)
+	checkLimitError(c, err, "MaxSliceLen", `too many macaroons \(limit 2\)`)
+}
+
+func (*cborSuite) TestUnmarshalCBORCaveatLimitCheckedFirst(c *gc.C) {
+	// The caveats are empty maps, so decoding
+	// them would fail.
+	data, err := hex.DecodeString("a3" + "0002" + "02426964" + "0383" + "a0a0a0")
+	c.Assert(err, gc.IsNil)
+	opts := macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	}
+	var m macaroon.Macaroon
+	err = opts.UnmarshalMacaroonCBOR(&m, data)
+	checkLimitError(c, err, "MaxCaveats", `too many caveats \(limit 2\)`)
+}
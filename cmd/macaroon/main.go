@@ -0,0 +1,420 @@
+// The macaroon command mints, attenuates, inspects, verifies and
+// converts macaroons. It is intended as a debugging aid for
+// operators rather than as a general purpose authorization tool.
+//
+// Usage:
+//
+//	macaroon mint -keyfile file -id id [-location loc] [-version n] [-caveat cond]...
+//	macaroon attenuate -caveat cond [-caveat cond]... [macaroon]
+//	macaroon inspect [macaroons]
+//	macaroon verify -keyfile file [-accept cond]... [macaroons]
+//	macaroon convert -to format [-version n] [macaroons]
+//
+// The root key used by mint and verify should be given with
+// -keyfile, which names a file holding the key exactly as it
+// should be used, with no trailing newline. The key may instead be
+// given on the command line with -key (as text) or -hexkey (in hex),
+// but that exposes it to other users of the machine through the
+// process list and is likely to leave it in the shell history.
+//
+// Macaroons are read from the first argument or, if there is no
+// argument, from standard input. They may be supplied as binary, as
+// base64-encoded binary, or as JSON (either a single macaroon object
+// or an array of macaroon objects). When several macaroons are
+// supplied, the first is treated as the primary macaroon and the rest
+// as its discharges.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctxt *context, args []string) error
+}
+
+var commands = []command{{
+	name:  "mint",
+	usage: "mint a new macaroon",
+	run:   mint,
+}, {
+	name:  "attenuate",
+	usage: "add first party caveats to a macaroon",
+	run:   attenuate,
+}, {
+	name:  "inspect",
+	usage: "print a human-readable form of macaroons",
+	run:   inspect,
+}, {
+	name:  "verify",
+	usage: "verify a macaroon and its discharges",
+	run:   verify,
+}, {
+	name:  "convert",
+	usage: "convert macaroons between encoding formats",
+	run:   convert,
+}}
+
+// context holds the I/O streams used by a command.
+type context struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// run runs the macaroon command with the given arguments
+// and returns its exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	ctxt := &context{
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	if len(args) == 0 {
+		ctxt.usage()
+		return 2
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		if err := cmd.run(ctxt, args[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return 2
+			}
+			fmt.Fprintf(stderr, "macaroon %s: %v\n", cmd.name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "macaroon: unknown command %q\n", args[0])
+	ctxt.usage()
+	return 2
+}
+
+func (ctxt *context) usage() {
+	fmt.Fprintf(ctxt.stderr, "usage: macaroon <command> [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(ctxt.stderr, "\t%-10s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// newFlagSet returns a new flag set for the given command
+// that reports errors to ctxt.stderr.
+func (ctxt *context) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("macaroon "+name, flag.ContinueOnError)
+	fs.SetOutput(ctxt.stderr)
+	return fs
+}
+
+// stringsFlag implements flag.Value by accumulating
+// all the values it is set to.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// keyFlags holds the flags used to specify a root key.
+type keyFlags struct {
+	key     string
+	hexKey  string
+	keyFile string
+}
+
+func (kf *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&kf.keyFile, "keyfile", "", "file holding the root key (preferred)")
+	fs.StringVar(&kf.key, "key", "", "root key as text (visible to other users)")
+	fs.StringVar(&kf.hexKey, "hexkey", "", "root key in hex (visible to other users)")
+}
+
+func (kf *keyFlags) rootKey() ([]byte, error) {
+	n := 0
+	for _, f := range []string{kf.key, kf.hexKey, kf.keyFile} {
+		if f != "" {
+			n++
+		}
+	}
+	switch {
+	case n > 1:
+		return nil, fmt.Errorf("cannot specify more than one of -key, -hexkey and -keyfile")
+	case kf.keyFile != "":
+		key, err := ioutil.ReadFile(kf.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read root key: %v", err)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("root key file %q is empty", kf.keyFile)
+		}
+		return key, nil
+	case kf.key != "":
+		return []byte(kf.key), nil
+	case kf.hexKey != "":
+		key, err := hex.DecodeString(kf.hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -hexkey: %v", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no root key specified")
+}
+
+func mint(ctxt *context, args []string) error {
+	fs := ctxt.newFlagSet("mint")
+	var kf keyFlags
+	kf.register(fs)
+	var caveats stringsFlag
+	id := fs.String("id", "", "macaroon identifier")
+	loc := fs.String("location", "", "macaroon location")
+	vers := fs.Int("version", int(macaroon.LatestVersion), "macaroon version")
+	format := fs.String("format", "base64", "output format (base64, binary or json)")
+	fs.Var(&caveats, "caveat", "first party caveat to add (may be repeated)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	rootKey, err := kf.rootKey()
+	if err != nil {
+		return err
+	}
+	m, err := macaroon.New(rootKey, []byte(*id), *loc, macaroon.Version(*vers))
+	if err != nil {
+		return err
+	}
+	if err := addCaveats(m, caveats); err != nil {
+		return err
+	}
+	return ctxt.write(macaroon.Slice{m}, *format)
+}
+
+func attenuate(ctxt *context, args []string) error {
+	fs := ctxt.newFlagSet("attenuate")
+	var caveats stringsFlag
+	format := fs.String("format", "base64", "output format (base64, binary or json)")
+	fs.Var(&caveats, "caveat", "first party caveat to add (may be repeated)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(caveats) == 0 {
+		return fmt.Errorf("no caveats specified")
+	}
+	ms, err := ctxt.read(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(ms) != 1 {
+		return fmt.Errorf("expected exactly one macaroon, got %d", len(ms))
+	}
+	if err := addCaveats(ms[0], caveats); err != nil {
+		return err
+	}
+	return ctxt.write(ms, *format)
+}
+
+func addCaveats(m *macaroon.Macaroon, caveats []string) error {
+	for _, cav := range caveats {
+		if err := m.AddFirstPartyCaveat(cav); err != nil {
+			return fmt.Errorf("cannot add caveat %q: %v", cav, err)
+		}
+	}
+	return nil
+}
+
+func inspect(ctxt *context, args []string) error {
+	fs := ctxt.newFlagSet("inspect")
+	verbose := fs.Bool("v", false, "print version and caveat types")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ms, err := ctxt.read(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		fmt.Fprintf(ctxt.stdout, "%+v", ms)
+	} else {
+		fmt.Fprintf(ctxt.stdout, "%v", ms)
+	}
+	return nil
+}
+
+func verify(ctxt *context, args []string) error {
+	fs := ctxt.newFlagSet("verify")
+	var kf keyFlags
+	kf.register(fs)
+	var accepted stringsFlag
+	fs.Var(&accepted, "accept", "first party caveat condition to accept (may be repeated)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rootKey, err := kf.rootKey()
+	if err != nil {
+		return err
+	}
+	ms, err := ctxt.read(fs.Args())
+	if err != nil {
+		return err
+	}
+	check := func(cond string) error {
+		for _, a := range accepted {
+			if cond == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("caveat %q not satisfied", cond)
+	}
+	if err := ms[0].Verify(rootKey, check, ms[1:]); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	fmt.Fprintf(ctxt.stdout, "OK\n")
+	return nil
+}
+
+func convert(ctxt *context, args []string) error {
+	fs := ctxt.newFlagSet("convert")
+	format := fs.String("to", "base64", "output format (base64, binary or json)")
+	vers := fs.Int("version", 0, "macaroon version to convert to (default unchanged)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ms, err := ctxt.read(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *vers != 0 {
+		for i, m := range ms {
+			m1, err := m.ConvertTo(macaroon.Version(*vers))
+			if err != nil {
+				return fmt.Errorf("cannot convert macaroon %d: %v", i, err)
+			}
+			ms[i] = m1
+		}
+	}
+	return ctxt.write(ms, *format)
+}
+
+// read reads macaroons from the first element of args,
+// or from standard input if args is empty.
+func (ctxt *context) read(args []string) (macaroon.Slice, error) {
+	var data []byte
+	switch len(args) {
+	case 0:
+		var err error
+		data, err = ioutil.ReadAll(ctxt.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read macaroon: %v", err)
+		}
+	case 1:
+		data = []byte(args[0])
+	default:
+		return nil, fmt.Errorf("unexpected arguments %q", args[1:])
+	}
+	ms, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("no macaroons found")
+	}
+	return ms, nil
+}
+
+// decode decodes macaroons from data, which may be in binary,
+// base64-encoded binary or JSON format.
+func decode(data []byte) (macaroon.Slice, error) {
+	if text := bytes.TrimSpace(data); len(text) > 0 {
+		switch text[0] {
+		case '{':
+			var m macaroon.Macaroon
+			if err := json.Unmarshal(text, &m); err != nil {
+				return nil, fmt.Errorf("cannot unmarshal JSON macaroon: %v", err)
+			}
+			return macaroon.Slice{&m}, nil
+		case '[':
+			var ms macaroon.Slice
+			if err := json.Unmarshal(text, &ms); err != nil {
+				return nil, fmt.Errorf("cannot unmarshal JSON macaroons: %v", err)
+			}
+			return ms, nil
+		}
+		if bin, err := base64Decode(text); err == nil {
+			data = bin
+		}
+	}
+	var ms macaroon.Slice
+	if err := ms.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// write writes the given macaroons to standard output
+// in the given format.
+func (ctxt *context) write(ms macaroon.Slice, format string) error {
+	var data []byte
+	var err error
+	switch format {
+	case "binary":
+		data, err = ms.MarshalBinary()
+	case "base64":
+		data, err = ms.MarshalBinary()
+		if err == nil {
+			data = []byte(base64.RawURLEncoding.EncodeToString(data) + "\n")
+		}
+	case "json":
+		if len(ms) == 1 {
+			data, err = json.Marshal(ms[0])
+		} else {
+			data, err = json.Marshal(ms)
+		}
+		if err == nil {
+			data = append(data, '\n')
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = ctxt.stdout.Write(data)
+	return err
+}
+
+// base64Decode base64-decodes the given data.
+// It accepts both standard padded encoding and unpadded
+// URL encoding.
+func base64Decode(data []byte) ([]byte, error) {
+	buf := make([]byte, base64.RawStdEncoding.DecodedLen(len(data)))
+	if n, err := base64.StdEncoding.Decode(buf, data); err == nil {
+		return buf[0:n], nil
+	}
+	n, err := base64.RawURLEncoding.Decode(buf, data)
+	if err == nil {
+		return buf[0:n], nil
+	}
+	return nil, err
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type mainSuite struct{}
+
+var _ = gc.Suite(&mainSuite{})
+
+// runCmd runs the macaroon command with the given arguments and
+// standard input, and returns its exit code, standard output and
+// standard error.
+func runCmd(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func (*mainSuite) TestMintAttenuateVerify(c *gc.C) {
+	code, out, errOut := runCmd("", "mint", "-key", "secret", "-id", "some id", "-location", "loc", "-caveat", "a caveat")
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	minted := strings.TrimSpace(out)
+
+	code, out, errOut = runCmd(minted, "attenuate", "-caveat", "another caveat")
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	attenuated := strings.TrimSpace(out)
+
+	code, out, errOut = runCmd("", "verify", "-key", "secret", "-accept", "a caveat", "-accept", "another caveat", attenuated)
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Equals, "OK\n")
+
+	code, out, errOut = runCmd(attenuated, "verify", "-key", "secret", "-accept", "a caveat")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(out, gc.Equals, "")
+	c.Assert(errOut, gc.Equals, `macaroon verify: verification failed: caveat "another caveat" not satisfied`+"\n")
+
+	code, _, errOut = runCmd(attenuated, "verify", "-key", "wrong", "-accept", "a caveat", "-accept", "another caveat")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon verify: verification failed: signature mismatch after caveat verification\n")
+}
+
+func (*mainSuite) TestMintHexKey(c *gc.C) {
+	code, out, _ := runCmd("", "mint", "-hexkey", "736563726574", "-id", "some id", "-version", "1")
+	c.Assert(code, gc.Equals, 0)
+	code, out, _ = runCmd(out, "verify", "-key", "secret")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Equals, "OK\n")
+}
+
+func (*mainSuite) TestKeyFile(c *gc.C) {
+	keyFile := filepath.Join(c.MkDir(), "key")
+	err := ioutil.WriteFile(keyFile, []byte("secret"), 0600)
+	c.Assert(err, gc.IsNil)
+	code, out, errOut := runCmd("", "mint", "-keyfile", keyFile, "-id", "some id")
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	minted := out
+
+	code, out, errOut = runCmd(minted, "verify", "-keyfile", keyFile)
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Equals, "OK\n")
+
+	// The key file holds the same key as -key.
+	code, out, _ = runCmd(minted, "verify", "-key", "secret")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Equals, "OK\n")
+}
+
+func (*mainSuite) TestKeyFileErrors(c *gc.C) {
+	dir := c.MkDir()
+	code, _, errOut := runCmd("", "mint", "-keyfile", filepath.Join(dir, "nonexistent"), "-id", "some id")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Matches, "macaroon mint: cannot read root key: .*\n")
+
+	emptyFile := filepath.Join(dir, "empty")
+	err := ioutil.WriteFile(emptyFile, nil, 0600)
+	c.Assert(err, gc.IsNil)
+	code, _, errOut = runCmd("", "mint", "-keyfile", emptyFile, "-id", "some id")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon mint: root key file \""+emptyFile+"\" is empty\n")
+
+	code, _, errOut = runCmd("", "mint", "-keyfile", emptyFile, "-key", "k")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon mint: cannot specify more than one of -key, -hexkey and -keyfile\n")
+}
+
+func (*mainSuite) TestMintErrors(c *gc.C) {
+	code, _, errOut := runCmd("", "mint", "-id", "some id")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon mint: no root key specified\n")
+
+	code, _, errOut = runCmd("", "mint", "-key", "k", "-hexkey", "00")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon mint: cannot specify more than one of -key, -hexkey and -keyfile\n")
+
+	code, _, errOut = runCmd("", "mint", "-key", "k", "-version", "3")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon mint: invalid version v3\n")
+}
+
+func (*mainSuite) TestInspect(c *gc.C) {
+	m, err := macaroon.New([]byte("secret"), []byte("some id"), "loc", macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	code, out, errOut := runCmd(string(data), "inspect")
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Equals, macaroon.Slice{m}.Inspect())
+
+	code, out, _ = runCmd(string(data), "inspect", "-v")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Matches, `(?s)# primary\n# version v2\n.*# caveat 0: first party\n.*`)
+}
+
+func (*mainSuite) TestConvert(c *gc.C) {
+	m, err := macaroon.New([]byte("secret"), []byte("some id"), "loc", macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	code, out, _ := runCmd(string(data), "convert", "-to", "base64")
+	c.Assert(code, gc.Equals, 0)
+	code, out, _ = runCmd(out, "convert", "-to", "binary")
+	c.Assert(code, gc.Equals, 0)
+	c.Assert(out, gc.Equals, string(data))
+
+	code, out, _ = runCmd(string(data), "convert", "-to", "json")
+	c.Assert(code, gc.Equals, 0)
+	expectJSON, err := json.Marshal(m)
+	c.Assert(err, gc.IsNil)
+	c.Assert(out, gc.Equals, string(expectJSON)+"\n")
+
+	code, _, errOut := runCmd(string(data), "convert", "-to", "xml")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, `macaroon convert: unknown format "xml"`+"\n")
+}
+
+func (*mainSuite) TestConvertVersion(c *gc.C) {
+	m, err := macaroon.New([]byte("secret"), []byte("some id"), "loc", macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	code, out, errOut := runCmd(string(data), "convert", "-to", "binary", "-version", "1")
+	c.Assert(errOut, gc.Equals, "")
+	c.Assert(code, gc.Equals, 0)
+	m1, err := m.ConvertTo(macaroon.V1)
+	c.Assert(err, gc.IsNil)
+	expectData, err := m1.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(out, gc.Equals, string(expectData))
+
+	m, err = macaroon.New([]byte("secret"), []byte("\xff"), "loc", macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	data, err = m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	code, _, errOut = runCmd(string(data), "convert", "-version", "1")
+	c.Assert(code, gc.Equals, 1)
+	c.Assert(errOut, gc.Equals, "macaroon convert: cannot convert macaroon 0: cannot convert to v1: macaroon id is not valid UTF-8\n")
+}
+
+func (*mainSuite) TestUnknownCommand(c *gc.C) {
+	code, _, errOut := runCmd("", "frobnicate")
+	c.Assert(code, gc.Equals, 2)
+	c.Assert(errOut, gc.Matches, `macaroon: unknown command "frobnicate"\nusage: .*(\n.*)*`)
+}
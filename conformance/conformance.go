@@ -0,0 +1,298 @@
+// Package conformance holds test vectors that check how macaroons
+// are created, signed and encoded, and a runner that checks this
+// package against them.
+//
+// Only some of the vectors come from libmacaroons: the signatures
+// and V1 encodings of the vectors taken from the libmacaroons
+// README. The remaining vectors and encodings were generated by
+// this package and serve to detect unintended changes and
+// differences between implementations; they have not been checked
+// against libmacaroons. The Source field of each vector records
+// where it came from.
+//
+// The vectors are held in the file vectors.json in this directory,
+// so that other implementations can use them too. Each vector
+// describes a macaroon created with a given root key, identifier,
+// location and sequence of caveats, along with the signature expected
+// after each step and the expected V1 and V2 binary and JSON
+// encodings of the result. Third party caveats specify the nonce
+// used to encrypt their verification ids so that the results are
+// deterministic.
+package conformance
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+// Vector holds a single test vector.
+type Vector struct {
+	// Name holds a short description of the vector.
+	Name string `json:"name"`
+
+	// Source records where the expected values in
+	// the vector came from.
+	Source string `json:"source"`
+
+	// RootKey, Id and Location hold the root key, identifier and
+	// location of the macaroon. RootKeyHex and IdHex may be
+	// used instead of RootKey and Id to hold hex-encoded
+	// values that are not valid UTF-8.
+	RootKey    string `json:"root_key,omitempty"`
+	RootKeyHex string `json:"root_key_hex,omitempty"`
+	Id         string `json:"id,omitempty"`
+	IdHex      string `json:"id_hex,omitempty"`
+	Location   string `json:"location"`
+
+	// Caveats holds the caveats to add, in order.
+	Caveats []Caveat `json:"caveats"`
+
+	// Signatures holds the hex-encoded signatures
+	// expected after the macaroon has been created and after
+	// each caveat has been added, so it always has one
+	// more element than Caveats.
+	Signatures []string `json:"signatures"`
+
+	// V1Binary and V2Binary hold the expected standard
+	// base64 encodings of the macaroon in V1 and V2 binary
+	// format. V1Binary is empty if the macaroon cannot be
+	// represented in V1 format.
+	V1Binary string `json:"v1_binary,omitempty"`
+	V2Binary string `json:"v2_binary"`
+
+	// V1JSON and V2JSON hold the expected encodings of
+	// the macaroon in V1 and V2 JSON format. Implementations
+	// may differ in the order of object fields and in how
+	// strings are escaped, so they should be compared
+	// after decoding.
+	V1JSON json.RawMessage `json:"v1_json,omitempty"`
+	V2JSON json.RawMessage `json:"v2_json"`
+}
+
+// Caveat holds a caveat in a test vector.
+type Caveat struct {
+	// Condition holds the caveat's condition for a first
+	// party caveat, or its identifier for a third party caveat.
+	// ConditionHex may be used instead to hold a
+	// hex-encoded condition that is not valid UTF-8.
+	Condition    string `json:"condition,omitempty"`
+	ConditionHex string `json:"condition_hex,omitempty"`
+
+	// Location holds the location of a third party caveat.
+	Location string `json:"location,omitempty"`
+
+	// RootKey holds the root key of a third party caveat.
+	// If this is empty, the caveat is a first party caveat.
+	RootKey string `json:"root_key,omitempty"`
+
+	// Nonce holds the hex-encoded 24 byte nonce used to
+	// encrypt the verification id of a third party caveat.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Load reads test vectors in JSON format from r.
+func Load(r io.Reader) ([]Vector, error) {
+	var vectors []Vector
+	if err := json.NewDecoder(r).Decode(&vectors); err != nil {
+		return nil, fmt.Errorf("cannot decode test vectors: %v", err)
+	}
+	return vectors, nil
+}
+
+// LoadFile reads test vectors in JSON format from
+// the named file.
+func LoadFile(path string) ([]Vector, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Run checks this package against all the given vectors
+// and returns an error for each one that fails.
+func Run(vectors []Vector) []error {
+	var errs []error
+	for _, v := range vectors {
+		if err := Check(v); err != nil {
+			errs = append(errs, fmt.Errorf("vector %q: %v", v.Name, err))
+		}
+	}
+	return errs
+}
+
+// Check checks that this package creates, signs, encodes and
+// decodes the macaroon described by v exactly as expected,
+// and that the macaroon verifies.
+func Check(v Vector) error {
+	if len(v.Signatures) != len(v.Caveats)+1 {
+		return fmt.Errorf("got %d signatures, want %d", len(v.Signatures), len(v.Caveats)+1)
+	}
+	rootKey, err := textOrHex(v.RootKey, v.RootKeyHex)
+	if err != nil {
+		return fmt.Errorf("invalid root key: %v", err)
+	}
+	id, err := textOrHex(v.Id, v.IdHex)
+	if err != nil {
+		return fmt.Errorf("invalid id: %v", err)
+	}
+	m, err := macaroon.New(rootKey, id, v.Location, macaroon.V2)
+	if err != nil {
+		return fmt.Errorf("cannot create macaroon: %v", err)
+	}
+	if err := checkSignature(m, v.Signatures[0]); err != nil {
+		return fmt.Errorf("after creation: %v", err)
+	}
+	for i, cav := range v.Caveats {
+		cond, err := textOrHex(cav.Condition, cav.ConditionHex)
+		if err != nil {
+			return fmt.Errorf("caveat %d: invalid condition: %v", i, err)
+		}
+		if cav.RootKey == "" {
+			err = m.AddFirstPartyCaveatBytes(cond)
+		} else {
+			var nonce []byte
+			nonce, err = hex.DecodeString(cav.Nonce)
+			if err != nil {
+				return fmt.Errorf("caveat %d: invalid nonce: %v", i, err)
+			}
+			err = m.AddThirdPartyCaveatWithRand([]byte(cav.RootKey), cond, cav.Location, bytes.NewReader(nonce))
+		}
+		if err != nil {
+			return fmt.Errorf("cannot add caveat %d: %v", i, err)
+		}
+		if err := checkSignature(m, v.Signatures[i+1]); err != nil {
+			return fmt.Errorf("after caveat %d: %v", i, err)
+		}
+	}
+	if err := verify(m, rootKey, v.Caveats); err != nil {
+		return fmt.Errorf("cannot verify macaroon: %v", err)
+	}
+	if err := checkBinary(m, macaroon.V2, v.V2Binary); err != nil {
+		return err
+	}
+	if err := checkJSON(m, macaroon.V2, v.V2JSON); err != nil {
+		return err
+	}
+	if v.V1Binary == "" {
+		if _, err := m.ConvertTo(macaroon.V1); err == nil {
+			return fmt.Errorf("no V1 encodings but macaroon is V1-compatible")
+		}
+		return nil
+	}
+	if err := checkBinary(m, macaroon.V1, v.V1Binary); err != nil {
+		return err
+	}
+	return checkJSON(m, macaroon.V1, v.V1JSON)
+}
+
+// verify checks that m verifies with all its first party
+// caveats satisfied and discharges for all its third party caveats.
+func verify(m *macaroon.Macaroon, rootKey []byte, caveats []Caveat) error {
+	var discharges []*macaroon.Macaroon
+	for _, cav := range caveats {
+		if cav.RootKey == "" {
+			continue
+		}
+		// The condition has already been checked by Check.
+		cond, _ := textOrHex(cav.Condition, cav.ConditionHex)
+		d, err := macaroon.New([]byte(cav.RootKey), cond, cav.Location, macaroon.V2)
+		if err != nil {
+			return err
+		}
+		d.Bind(m.Signature())
+		discharges = append(discharges, d)
+	}
+	return m.VerifyBytes(rootKey, func([]byte) error {
+		return nil
+	}, discharges)
+}
+
+func checkSignature(m *macaroon.Macaroon, expect string) error {
+	if got := hex.EncodeToString(m.Signature()); got != expect {
+		return fmt.Errorf("got signature %s, want %s", got, expect)
+	}
+	return nil
+}
+
+// checkBinary checks that m marshals to the given base64-encoded
+// binary data in the given version, and that the data
+// unmarshals to the same macaroon.
+func checkBinary(m *macaroon.Macaroon, vers macaroon.Version, expect string) error {
+	expectData, err := base64.StdEncoding.DecodeString(expect)
+	if err != nil {
+		return fmt.Errorf("invalid %v binary data: %v", vers, err)
+	}
+	m, err = m.ConvertTo(vers)
+	if err != nil {
+		return err
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("cannot marshal %v binary: %v", vers, err)
+	}
+	if !bytes.Equal(data, expectData) {
+		return fmt.Errorf("%v binary mismatch; got %s", vers, base64.StdEncoding.EncodeToString(data))
+	}
+	var m1 macaroon.Macaroon
+	if err := m1.UnmarshalBinary(expectData); err != nil {
+		return fmt.Errorf("cannot unmarshal %v binary: %v", vers, err)
+	}
+	if !m1.Equal(m) || m1.Version() != vers {
+		return fmt.Errorf("%v binary unmarshals to different macaroon", vers)
+	}
+	return nil
+}
+
+// checkJSON checks that m marshals to the given JSON
+// in the given version, and that the JSON unmarshals
+// to the same macaroon.
+func checkJSON(m *macaroon.Macaroon, vers macaroon.Version, expect json.RawMessage) error {
+	m, err := m.ConvertTo(vers)
+	if err != nil {
+		return err
+	}
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("cannot marshal %v JSON: %v", vers, err)
+	}
+	var got, want interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(expect, &want); err != nil {
+		return fmt.Errorf("invalid %v JSON: %v", vers, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("%v JSON mismatch; got %s", vers, data)
+	}
+	var m1 macaroon.Macaroon
+	if err := m1.UnmarshalJSON(expect); err != nil {
+		return fmt.Errorf("cannot unmarshal %v JSON: %v", vers, err)
+	}
+	if !m1.Equal(m) || m1.Version() != vers {
+		return fmt.Errorf("%v JSON unmarshals to different macaroon", vers)
+	}
+	return nil
+}
+
+// textOrHex returns the text if hexText is empty,
+// or the hex-decoded hexText otherwise.
+func textOrHex(text, hexText string) ([]byte, error) {
+	if hexText == "" {
+		return []byte(text), nil
+	}
+	if text != "" {
+		return nil, fmt.Errorf("both text and hex values specified")
+	}
+	return hex.DecodeString(hexText)
+}
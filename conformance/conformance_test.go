@@ -0,0 +1,97 @@
+package conformance_test
+
+import (
+	"strings"
+	"testing"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable/conformance"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type suite struct{}
+
+var _ = gc.Suite(&suite{})
+
+func (*suite) TestVectors(c *gc.C) {
+	vectors, err := conformance.LoadFile("vectors.json")
+	c.Assert(err, gc.IsNil)
+	c.Assert(conformance.Run(vectors), gc.HasLen, 0)
+}
+
+func (*suite) TestVectorsHaveSource(c *gc.C) {
+	vectors, err := conformance.LoadFile("vectors.json")
+	c.Assert(err, gc.IsNil)
+	for _, v := range vectors {
+		c.Check(v.Source, gc.Not(gc.Equals), "", gc.Commentf("vector %q", v.Name))
+	}
+}
+
+var checkErrorTests = []struct {
+	about       string
+	edit        func(v *conformance.Vector)
+	expectError string
+}{{
+	about: "wrong initial signature",
+	edit: func(v *conformance.Vector) {
+		v.Signatures[0] = strings.Repeat("00", 32)
+	},
+	expectError: `after creation: got signature e3d9e0.* want 0000.*`,
+}, {
+	about: "wrong caveat signature",
+	edit: func(v *conformance.Vector) {
+		v.Caveats[1].Condition = "time < 2030-01-01T00:00"
+	},
+	expectError: `after caveat 1: got signature .*`,
+}, {
+	about: "wrong number of signatures",
+	edit: func(v *conformance.Vector) {
+		v.Signatures = v.Signatures[1:]
+	},
+	expectError: `got 2 signatures, want 3`,
+}, {
+	about: "wrong binary",
+	edit: func(v *conformance.Vector) {
+		v.V2Binary = "AA=="
+	},
+	expectError: `v2 binary mismatch; got .*`,
+}, {
+	about: "wrong JSON",
+	edit: func(v *conformance.Vector) {
+		v.V1JSON = []byte(`{}`)
+	},
+	expectError: `v1 JSON mismatch; got .*`,
+}, {
+	about: "missing V1 encodings",
+	edit: func(v *conformance.Vector) {
+		v.V1Binary = ""
+	},
+	expectError: `no V1 encodings but macaroon is V1-compatible`,
+}, {
+	about: "text and hex",
+	edit: func(v *conformance.Vector) {
+		v.IdHex = "00"
+	},
+	expectError: `invalid id: both text and hex values specified`,
+}}
+
+func (*suite) TestCheckError(c *gc.C) {
+	for i, test := range checkErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		vectors, err := conformance.LoadFile("vectors.json")
+		c.Assert(err, gc.IsNil)
+		v := vectors[0]
+		test.edit(&v)
+		err = conformance.Check(v)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (*suite) TestLoadError(c *gc.C) {
+	_, err := conformance.Load(strings.NewReader("{"))
+	c.Assert(err, gc.ErrorMatches, `cannot decode test vectors: .*`)
+}
@@ -54,7 +54,7 @@ const (
 
 func newNonce(r io.Reader) (*[nonceLen]byte, error) {
 	var nonce [nonceLen]byte
-	_, err := r.Read(nonce[:])
+	_, err := io.ReadFull(r, nonce[:])
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate random bytes: %v", err)
 	}
@@ -0,0 +1,255 @@
+package macaroon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxDecodeSize holds the default maximum size in bytes
+// of a single macaroon read by a Decoder.
+const DefaultMaxDecodeSize = 1024 * 1024
+
+// readChunkSize holds the maximum number of bytes
+// that a Decoder reads from its input at once.
+const readChunkSize = 32 * 1024
+
+// Decoder reads a sequence of binary-encoded macaroons from
+// an input stream. Each macaroon may be in either V1 or V2 binary
+// format, so the stream may be produced by concatenating the
+// results of Macaroon.MarshalBinary or by Slice.MarshalBinary.
+//
+// The Decoder reads exactly the bytes that make up each macaroon
+// and no more, using the packet length prefixes to determine how
+// much to read. It does no buffering of its own, so for efficiency
+// the underlying reader should usually be buffered, for example
+// with bufio.NewReader.
+//
+// By default, the caveat and field length limits in
+// DefaultUnmarshalOptions are enforced for each macaroon read;
+// use SetOptions to change them.
+type Decoder struct {
+	r    io.Reader
+	opts UnmarshalOptions
+
+	// buf holds the data read so far for the
+	// macaroon currently being decoded.
+	buf []byte
+}
+
+// NewDecoder returns a new decoder that reads from r.
+// It uses the current value of DefaultUnmarshalOptions,
+// except that it will refuse to read any macaroon larger
+// than DefaultMaxDecodeSize bytes.
+func NewDecoder(r io.Reader) *Decoder {
+	d := &Decoder{
+		r:    r,
+		opts: DefaultUnmarshalOptions,
+	}
+	d.opts.MaxSize = DefaultMaxDecodeSize
+	return d
+}
+
+// SetOptions sets the options used when decoding. The MaxSize
+// limit applies to each macaroon read; MaxSliceLen and
+// StrictJSON are ignored. As with UnmarshalOptions elsewhere,
+// a zero limit means that the quantity is unlimited.
+func (d *Decoder) SetOptions(o UnmarshalOptions) {
+	d.opts = o
+}
+
+// SetMaxSize sets the maximum size in bytes of any single
+// macaroon read by the decoder. Zero means no limit.
+func (d *Decoder) SetMaxSize(n int) {
+	d.opts.MaxSize = n
+}
+
+// Decode reads the next macaroon from the input stream.
+// At the end of the stream, it returns io.EOF; if the
+// stream ends part way through a macaroon it returns
+// io.ErrUnexpectedEOF.
+func (d *Decoder) Decode() (*Macaroon, error) {
+	// Use a new buffer for every macaroon because
+	// the parsed macaroon retains references to it.
+	d.buf = nil
+	if err := d.readN(1); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	var vers Version
+	var err error
+	switch v := d.buf[0]; {
+	case v == 2:
+		vers, err = V2, d.readV2()
+	case isASCIIHex(v):
+		vers, err = V1, d.readV1()
+	default:
+		return nil, fmt.Errorf("cannot determine data format of binary-encoded macaroon")
+	}
+	if _, ok := err.(*LimitError); ok || err == io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %v: %v", vers, err)
+	}
+	var m Macaroon
+	rest, err := m.parseBinary(d.buf, &d.opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		// Should be impossible because we've read exactly
+		// one macaroon, but be defensive.
+		return nil, fmt.Errorf("unexpected data after macaroon")
+	}
+	if d.opts.RequireCanonical {
+		if err := m.checkCanonical(d.buf); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+// readV1 reads the remainder of a V1 binary macaroon,
+// the first byte of which has already been read.
+func (d *Decoder) readV1() error {
+	// Note that the first byte of the first packet's
+	// size has already been read.
+	for start := 0; ; start = len(d.buf) {
+		if err := d.readN(start + 4 - len(d.buf)); err != nil {
+			return err
+		}
+		plen, ok := parseSizeV1(d.buf[start:])
+		if !ok {
+			return fmt.Errorf("cannot parse size")
+		}
+		if plen < 4 {
+			return fmt.Errorf("packet size too small")
+		}
+		if err := d.readN(plen - 4); err != nil {
+			return err
+		}
+		p, err := parsePacketV1(d.buf[start:])
+		if err != nil {
+			return err
+		}
+		if string(p.fieldName) == fieldNameSignature {
+			return nil
+		}
+	}
+}
+
+// readV2 reads the remainder of a V2 binary macaroon,
+// the version byte of which has already been read.
+func (d *Decoder) readV2() error {
+	// Read the header section and all the caveat
+	// sections. The caveats are terminated by an empty
+	// section.
+	for i := 0; ; i++ {
+		n, err := d.readSectionV2()
+		if err != nil {
+			return err
+		}
+		if i > 0 && n == 0 {
+			break
+		}
+	}
+	// Read the signature.
+	ft, err := d.readPacketV2()
+	if err != nil {
+		return err
+	}
+	if ft != fieldSignature {
+		return fmt.Errorf("unexpected field found instead of signature")
+	}
+	return nil
+}
+
+// readSectionV2 reads a sequence of V2 packets up to and
+// including the terminating EOS packet and returns the
+// number of packets read, not including the EOS packet.
+func (d *Decoder) readSectionV2() (int, error) {
+	for n := 0; ; n++ {
+		ft, err := d.readPacketV2()
+		if err != nil {
+			return 0, err
+		}
+		if ft == fieldEOS {
+			return n, nil
+		}
+	}
+}
+
+// readPacketV2 reads a single V2 packet and returns its field type.
+func (d *Decoder) readPacketV2() (fieldType, error) {
+	ft, err := d.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if fieldType(ft) == fieldEOS {
+		return fieldEOS, nil
+	}
+	payloadLen, err := d.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	if err := d.readN(payloadLen); err != nil {
+		return 0, err
+	}
+	return fieldType(ft), nil
+}
+
+// readVarint reads a variable-length integer as
+// parsed by parseVarint.
+func (d *Decoder) readVarint() (int, error) {
+	start := len(d.buf)
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if err := d.readN(1); err != nil {
+			return 0, err
+		}
+		if d.buf[len(d.buf)-1] < 0x80 {
+			break
+		}
+	}
+	_, x, err := parseVarint(d.buf[start:])
+	return x, err
+}
+
+// readN reads exactly n more bytes into d.buf.
+//
+// The length of a packet is controlled by the sender, so
+// rather than allocating space for all n bytes up front,
+// it reads at most readChunkSize bytes at a time and grows
+// the buffer only as data actually arrives.
+func (d *Decoder) readN(n int) error {
+	size := len(d.buf) + n
+	if err := d.opts.checkSize(size); err != nil {
+		return err
+	}
+	for len(d.buf) < size {
+		end := size
+		if end-len(d.buf) > readChunkSize {
+			end = len(d.buf) + readChunkSize
+		}
+		if end > cap(d.buf) {
+			bufCap := end * 2
+			if maxSize := d.opts.MaxSize; maxSize > 0 && bufCap > maxSize {
+				bufCap = maxSize
+			}
+			buf := make([]byte, len(d.buf), bufCap)
+			copy(buf, d.buf)
+			d.buf = buf
+		}
+		_, err := io.ReadFull(d.r, d.buf[len(d.buf):end])
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		d.buf = d.buf[:end]
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package macaroon_test
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type decodeSuite struct{}
+
+var _ = gc.Suite(&decodeSuite{})
+
+func decodeTestMacaroons(c *gc.C) macaroon.Slice {
+	rootKey := []byte("secret")
+	var ms macaroon.Slice
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2, macaroon.V2, macaroon.V1} {
+		m := MustNew(rootKey, []byte("some id"), "a location", vers)
+		err := m.AddFirstPartyCaveat("a caveat")
+		c.Assert(err, gc.IsNil)
+		err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+		c.Assert(err, gc.IsNil)
+		ms = append(ms, m)
+	}
+	// Include a macaroon without any location or caveats.
+	ms = append(ms, MustNew(rootKey, []byte("other id"), "", macaroon.V2))
+	return ms
+}
+
+func (*decodeSuite) TestDecode(c *gc.C) {
+	ms := decodeTestMacaroons(c)
+	data, err := ms.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	d := macaroon.NewDecoder(bytes.NewReader(data))
+	var got macaroon.Slice
+	for {
+		m, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		got = append(got, m)
+	}
+	c.Assert(got, jc.DeepEquals, ms)
+}
+
+func (*decodeSuite) TestDecodeReadsExactly(c *gc.C) {
+	for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+		c.Logf("version %v", vers)
+		m := MustNew([]byte("secret"), []byte("some id"), "a location", vers)
+		data, err := m.MarshalBinary()
+		c.Assert(err, gc.IsNil)
+		r := strings.NewReader(string(data) + "trailing data")
+		m1, err := macaroon.NewDecoder(r).Decode()
+		c.Assert(err, gc.IsNil)
+		c.Assert(m1, jc.DeepEquals, m)
+		c.Assert(r.Len(), gc.Equals, len("trailing data"))
+	}
+}
+
+func (*decodeSuite) TestDecodeTruncated(c *gc.C) {
+	ms := decodeTestMacaroons(c)
+	for _, m := range ms {
+		data, err := m.MarshalBinary()
+		c.Assert(err, gc.IsNil)
+		for i := 1; i < len(data); i++ {
+			_, err := macaroon.NewDecoder(bytes.NewReader(data[0:i])).Decode()
+			c.Assert(err, gc.Equals, io.ErrUnexpectedEOF, gc.Commentf("length %d", i))
+		}
+	}
+}
+
+func (*decodeSuite) TestDecodeMaxSize(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte(strings.Repeat("x", 1000)), "", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	d := macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetMaxSize(len(data) - 1)
+	_, err = d.Decode()
+	checkLimitError(c, err, "MaxSize", `macaroon data too large \(limit 1039 bytes\)`)
+
+	d = macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetMaxSize(len(data))
+	m1, err := d.Decode()
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1, jc.DeepEquals, m)
+}
+
+var decodeErrorTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	about:       "unknown format",
+	data:        "\x03",
+	expectError: `cannot determine data format of binary-encoded macaroon`,
+}, {
+	about:       "bad V1 packet size",
+	data:        "0zzz",
+	expectError: `read v1: cannot parse size`,
+}, {
+	about:       "V1 packet size too small",
+	data:        "0002",
+	expectError: `read v1: packet size too small`,
+}, {
+	about:       "bad V1 field name",
+	data:        "0014fieldwithoutspace\n",
+	expectError: `read v1: cannot parse field name`,
+}, {
+	about:       "V2 varint out of range",
+	data:        "\x02\xff\xff\xff\xff\xff\xff\xff\xff\xff\x7f",
+	expectError: `read v2: varint value out of range`,
+}, {
+	about:       "V2 missing signature",
+	data:        "\x02\x02\x01a\x00\x00\x02\x01b",
+	expectError: `read v2: unexpected field found instead of signature`,
+}, {
+	about:       "V2 invalid macaroon",
+	data:        "\x02\x02\x01a\x00\x00\x06\x01b",
+	expectError: `unmarshal v2: signature has unexpected length`,
+}}
+
+func (*decodeSuite) TestDecodeError(c *gc.C) {
+	for i, test := range decodeErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		_, err := macaroon.NewDecoder(strings.NewReader(test.data)).Decode()
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (*decodeSuite) TestDecodeUnlimitedSize(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte(strings.Repeat("x", 1000)), "", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	d := macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetMaxSize(0)
+	m1, err := d.Decode()
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1, jc.DeepEquals, m)
+}
+
+func (*decodeSuite) TestDecodeLargePacketLength(c *gc.C) {
+	// A short input that claims to hold a packet of about
+	// 2GB should fail without allocating space for it all.
+	data := "\x02\x02\xff\xff\xff\xff\x07abc"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	d := macaroon.NewDecoder(strings.NewReader(data))
+	d.SetMaxSize(0)
+	_, err := d.Decode()
+	c.Assert(err, gc.Equals, io.ErrUnexpectedEOF)
+	runtime.ReadMemStats(&after)
+	allocated := after.TotalAlloc - before.TotalAlloc
+	c.Assert(allocated < 1024*1024, jc.IsTrue, gc.Commentf("%d bytes allocated", allocated))
+}
+
+func (*decodeSuite) TestDecodeSetOptions(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	for _, cond := range []string{"a", "b", "c"} {
+		err := m.AddFirstPartyCaveat(cond)
+		c.Assert(err, gc.IsNil)
+	}
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	d := macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetOptions(macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	})
+	_, err = d.Decode()
+	c.Assert(err, gc.ErrorMatches, `too many caveats \(limit 2\)`)
+
+	d = macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetOptions(macaroon.UnmarshalOptions{
+		MaxSize: len(data) - 1,
+	})
+	_, err = d.Decode()
+	checkLimitError(c, err, "MaxSize", `macaroon data too large \(limit .* bytes\)`)
+
+	// The zero options impose no limits.
+	d = macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetOptions(macaroon.UnmarshalOptions{})
+	m1, err := d.Decode()
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1, jc.DeepEquals, m)
+}
+
+func (*decodeSuite) TestDecodeRequireCanonical(c *gc.C) {
+	// A V2 macaroon with an empty location field,
+	// which is not canonical.
+	m := MustNew([]byte("secret"), []byte("id"), "", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	data = append([]byte("\x02\x01\x00"), data[1:]...)
+
+	m1, err := macaroon.NewDecoder(bytes.NewReader(data)).Decode()
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Signature(), jc.DeepEquals, m.Signature())
+
+	d := macaroon.NewDecoder(bytes.NewReader(data))
+	d.SetOptions(macaroon.UnmarshalOptions{
+		RequireCanonical: true,
+	})
+	_, err = d.Decode()
+	c.Assert(err, gc.ErrorMatches, `non-canonical v2 encoding: empty location field`)
+}
@@ -0,0 +1,145 @@
+package macaroon
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Digest returns a SHA-256 digest that identifies the macaroon,
+// suitable for use as a cache key or in logs. The digest does
+// not depend on the macaroon's version or the format it was
+// unmarshaled from, and is guaranteed to remain the same in
+// future releases of this package.
+//
+// The digest covers the location hints and any fields not
+// understood by this package, none of which are covered by the
+// signature, so anyone holding a macaroon can produce any
+// number of macaroons with different digests that verify in
+// exactly the same way. The digest must therefore not be used
+// to revoke or blacklist macaroons; use SignedDigest for that.
+func (m *Macaroon) Digest() [sha256.Size]byte {
+	return sha256.Sum256(m.appendDigestInput(nil))
+}
+
+// SignedDigest returns a SHA-256 digest of the parts of the
+// macaroon that are covered by its signature: the identifier,
+// the caveat identifiers and verification ids, and the
+// signature itself. Unlike Digest, it cannot be changed
+// without the macaroon's root key, so it is suitable for use
+// as a revocation key. Like Digest, it is guaranteed to remain
+// the same in future releases of this package.
+//
+// The digest is the SHA-256 hash of the following, where each
+// length is encoded as an unsigned varint:
+//
+//	len(id) id
+//	number of caveats
+//	for each caveat: len(cav.Id) cav.Id len(cav.VerificationId) cav.VerificationId
+//	signature
+func (m *Macaroon) SignedDigest() [sha256.Size]byte {
+	data := appendDigestBytes(nil, m.id)
+	data = appendDigestVarint(data, len(m.caveats))
+	for _, cav := range m.caveats {
+		data = appendDigestBytes(data, cav.Id)
+		data = appendDigestBytes(data, cav.VerificationId)
+	}
+	data = append(data, m.sig[:]...)
+	return sha256.Sum256(data)
+}
+
+// Digest returns a SHA-256 digest that identifies the macaroons
+// in the slice. Like Macaroon.Digest, it does not depend on the
+// macaroons' versions, is guaranteed to remain the same in
+// future releases of this package and must not be used for
+// revocation. It returns an error if the slice holds a nil
+// macaroon.
+//
+// The digest is the SHA-256 hash of the concatenated inputs
+// to Macaroon.Digest for each macaroon, so the digest of a
+// slice holding a single macaroon is the same as the digest
+// of the macaroon.
+func (s Slice) Digest() ([sha256.Size]byte, error) {
+	var data []byte
+	for i, m := range s {
+		if m == nil {
+			return [sha256.Size]byte{}, fmt.Errorf("nil macaroon at index %d", i)
+		}
+		data = m.appendDigestInput(data)
+	}
+	return sha256.Sum256(data), nil
+}
+
+// appendDigestInput appends the data hashed by Digest to data.
+// It is defined here rather than in terms of the binary encoders
+// so that changes to the encoders cannot change any digest.
+// The data is laid out as a V2 binary macaroon was when Digest
+// was introduced:
+//
+//	the byte 2
+//	header section: [location] identifier
+//	for each caveat, a section: [location] identifier [verification id]
+//	an empty section
+//	the signature field
+//
+// Each section is a sequence of fields terminated by a zero
+// byte. Each field is encoded as its type, its length and
+// its data, with the type and length encoded as unsigned
+// varints. The field types are 1 for a location, 2 for an
+// identifier, 4 for a verification id and 6 for the signature.
+// Location and verification id fields are omitted when empty.
+// Any extra fields are merged into their section in ascending
+// order of type, before any known field of a higher type.
+func (m *Macaroon) appendDigestInput(data []byte) []byte {
+	data = append(data, 2)
+	data = appendDigestSection(data, m.location, m.id, nil, m.extra)
+	for _, cav := range m.caveats {
+		data = appendDigestSection(data, cav.Location, cav.Id, cav.VerificationId, cav.Extra)
+	}
+	data = append(data, 0)
+	return appendDigestField(data, 6, m.sig[:])
+}
+
+// appendDigestSection appends a section of the digest input
+// as described in appendDigestInput.
+func appendDigestSection(data []byte, location string, id, vid []byte, extra []Field) []byte {
+	type field struct {
+		typ  int
+		data []byte
+	}
+	fields := make([]field, 0, 3)
+	if location != "" {
+		fields = append(fields, field{1, []byte(location)})
+	}
+	fields = append(fields, field{2, id})
+	if len(vid) > 0 {
+		fields = append(fields, field{4, vid})
+	}
+	for _, f := range extra {
+		for len(fields) > 0 && fields[0].typ < f.Type {
+			data = appendDigestField(data, fields[0].typ, fields[0].data)
+			fields = fields[1:]
+		}
+		data = appendDigestField(data, f.Type, f.Data)
+	}
+	for _, f := range fields {
+		data = appendDigestField(data, f.typ, f.data)
+	}
+	return append(data, 0)
+}
+
+func appendDigestField(data []byte, typ int, fieldData []byte) []byte {
+	data = appendDigestVarint(data, typ)
+	return appendDigestBytes(data, fieldData)
+}
+
+func appendDigestBytes(data []byte, b []byte) []byte {
+	data = appendDigestVarint(data, len(b))
+	return append(data, b...)
+}
+
+func appendDigestVarint(data []byte, x int) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(x))
+	return append(data, buf[:n]...)
+}
@@ -0,0 +1,140 @@
+package macaroon_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type digestSuite struct{}
+
+var _ = gc.Suite(&digestSuite{})
+
+func (*digestSuite) TestDigest(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	digest := m.Digest()
+
+	// The digest must never change.
+	c.Assert(hex.EncodeToString(digest[:]), gc.Equals, "d9f6e13c4471ead97d4d12d16e6b669974be8fe7e3c7c964670ea39add890d44")
+
+	// The digest is the same regardless of the encoding.
+	m2, err := m.ConvertTo(macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m2.Digest(), gc.Equals, digest)
+
+	for _, m := range []*macaroon.Macaroon{m, m2} {
+		data, err := m.MarshalJSON()
+		c.Assert(err, gc.IsNil)
+		var m1 macaroon.Macaroon
+		err = m1.UnmarshalJSON(data)
+		c.Assert(err, gc.IsNil)
+		c.Assert(m1.Digest(), gc.Equals, digest)
+
+		data, err = m.MarshalBinary()
+		c.Assert(err, gc.IsNil)
+		var m2 macaroon.Macaroon
+		err = m2.UnmarshalBinary(data)
+		c.Assert(err, gc.IsNil)
+		c.Assert(m2.Digest(), gc.Equals, digest)
+	}
+
+	// Any change to the macaroon changes the digest.
+	m3 := m.Clone()
+	err = m3.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m3.Digest(), gc.Not(gc.Equals), digest)
+
+	m3 = MustNew([]byte("secret"), []byte("some id"), "another location", macaroon.V1)
+	err = m3.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m3.Signature(), gc.DeepEquals, m.Signature())
+	c.Assert(m3.Digest(), gc.Not(gc.Equals), digest)
+}
+
+func (*digestSuite) TestSliceDigest(c *gc.C) {
+	m0 := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	m1 := MustNew([]byte("another secret"), []byte("another id"), "", macaroon.V2)
+	digest, err := macaroon.Slice{m0, m1}.Digest()
+	c.Assert(err, gc.IsNil)
+	c.Assert(hex.EncodeToString(digest[:]), gc.Equals, "6ac9b5bc8403fe5dd35b1162a56968ef185de20265aaccde702fd342dccf5435")
+
+	digest1, err := macaroon.Slice{m1, m0}.Digest()
+	c.Assert(err, gc.IsNil)
+	c.Assert(digest1, gc.Not(gc.Equals), digest)
+	digest1, err = macaroon.Slice{m0}.Digest()
+	c.Assert(err, gc.IsNil)
+	c.Assert(digest1, gc.Equals, m0.Digest())
+}
+
+func (*digestSuite) TestDigestInput(c *gc.C) {
+	// The digest input is defined independently of the
+	// binary encoders; check that it matches its documented
+	// layout for a macaroon with all kinds of field.
+	m := MustNew([]byte("secret"), []byte("id"), "loc", macaroon.V2)
+	err := m.AddFirstPartyCaveat("c1")
+	c.Assert(err, gc.IsNil)
+	data := unknownFieldsV2 + "\x06\x20" + string(m.Signature())
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary([]byte(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Digest(), gc.Equals, sha256.Sum256([]byte(data)))
+}
+
+func (*digestSuite) TestSignedDigest(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	err = m.AddThirdPartyCaveatWithRand([]byte("3rd party key"), []byte("3rd party caveat"), "remote.com", zeroReader{})
+	c.Assert(err, gc.IsNil)
+	digest := m.SignedDigest()
+
+	// The digest must never change.
+	c.Assert(hex.EncodeToString(digest[:]), gc.Equals, "d426fe522cb990bdecf5488d678516b115e2c8b4e6b379675d600dd4527a33de")
+
+	// The digest is the same regardless of the version.
+	m2, err := m.ConvertTo(macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m2.SignedDigest(), gc.Equals, digest)
+
+	// Changing the unsigned location hints changes the
+	// digest but not the signed digest.
+	m3 := MustNew([]byte("secret"), []byte("some id"), "another location", macaroon.V1)
+	err = m3.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	err = m3.AddThirdPartyCaveatWithRand([]byte("3rd party key"), []byte("3rd party caveat"), "elsewhere.com", zeroReader{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(m3.Digest(), gc.Not(gc.Equals), m.Digest())
+	c.Assert(m3.SignedDigest(), gc.Equals, digest)
+
+	// Attenuating the macaroon changes the signed digest.
+	m3 = m.Clone()
+	err = m3.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m3.SignedDigest(), gc.Not(gc.Equals), digest)
+}
+
+func (*digestSuite) TestSignedDigestIgnoresExtraFields(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("id"), "", macaroon.V2)
+	err := m.AddFirstPartyCaveat("c1")
+	c.Assert(err, gc.IsNil)
+
+	// Add unsigned fields to the header and the caveat.
+	data := "\x02" +
+		"\x02\x02id" + "\x03\x02h3" + "\x00" +
+		"\x02\x02c1" + "\x05\x02c5" + "\x00" +
+		"\x00" +
+		"\x06\x20" + string(m.Signature())
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary([]byte(data))
+	c.Assert(err, gc.IsNil)
+	err = m1.Verify([]byte("secret"), func(string) error { return nil }, nil)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(m1.Digest(), gc.Not(gc.Equals), m.Digest())
+	c.Assert(m1.SignedDigest(), gc.Equals, m.SignedDigest())
+}
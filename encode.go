@@ -0,0 +1,108 @@
+package macaroon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// EncoderFormat specifies the format in which
+// an Encoder writes macaroons.
+type EncoderFormat int
+
+const (
+	// BinaryFormat specifies that macaroons are written
+	// in the binary format determined by their version,
+	// with no separators, as read by Decoder.
+	BinaryFormat EncoderFormat = iota
+
+	// JSONLinesFormat specifies that each macaroon is
+	// written in JSON format on a line of its own.
+	JSONLinesFormat
+
+	// Base64LinesFormat specifies that each macaroon is
+	// written as the unpadded URL-safe base64 encoding of its
+	// binary format on a line of its own, as produced by
+	// Macaroon.MarshalText.
+	Base64LinesFormat
+)
+
+// Encoder writes a sequence of macaroons to an output stream.
+type Encoder struct {
+	w      io.Writer
+	format EncoderFormat
+	json   JSONEncoder
+
+	// buf and textBuf hold buffers that are reused
+	// for each macaroon written.
+	buf     []byte
+	textBuf []byte
+}
+
+// NewEncoder returns a new encoder that writes
+// macaroons to w in the given format.
+func NewEncoder(w io.Writer, format EncoderFormat) *Encoder {
+	return &Encoder{
+		w:      w,
+		format: format,
+	}
+}
+
+// SetJSONEncoder sets the options used to encode macaroons
+// when the encoder's format is JSONLinesFormat.
+func (e *Encoder) SetJSONEncoder(je JSONEncoder) {
+	e.json = je
+}
+
+// Encode writes m to the output stream.
+func (e *Encoder) Encode(m *Macaroon) error {
+	data, err := e.encode(m)
+	if err != nil {
+		return fmt.Errorf("cannot encode macaroon %q: %v", m.Id(), err)
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+// encode returns the encoded form of m. The returned
+// data is only valid until the next call to encode.
+func (e *Encoder) encode(m *Macaroon) ([]byte, error) {
+	switch e.format {
+	case BinaryFormat:
+		data, err := m.appendBinary(e.buf[:0])
+		if err != nil {
+			return nil, err
+		}
+		e.buf = data
+		return data, nil
+	case Base64LinesFormat:
+		data, err := m.appendBinary(e.buf[:0])
+		if err != nil {
+			return nil, err
+		}
+		e.buf = data
+		e.textBuf = appendBase64Line(e.textBuf[:0], data)
+		return e.textBuf, nil
+	case JSONLinesFormat:
+		data, err := e.json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return nil, fmt.Errorf("unknown encoder format %d", e.format)
+}
+
+// appendBase64Line appends the unpadded URL-safe base64
+// encoding of data followed by a newline to buf.
+func appendBase64Line(buf, data []byte) []byte {
+	n := base64.RawURLEncoding.EncodedLen(len(data))
+	if len(buf)+n+1 > cap(buf) {
+		buf1 := make([]byte, len(buf), len(buf)+n+1)
+		copy(buf1, buf)
+		buf = buf1
+	}
+	base64.RawURLEncoding.Encode(buf[len(buf):len(buf)+n], data)
+	buf = buf[:len(buf)+n]
+	return append(buf, '\n')
+}
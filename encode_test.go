@@ -0,0 +1,106 @@
+package macaroon_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type encodeSuite struct{}
+
+var _ = gc.Suite(&encodeSuite{})
+
+func (*encodeSuite) TestEncodeBinary(c *gc.C) {
+	ms := decodeTestMacaroons(c)
+	var buf bytes.Buffer
+	e := macaroon.NewEncoder(&buf, macaroon.BinaryFormat)
+	for _, m := range ms {
+		err := e.Encode(m)
+		c.Assert(err, gc.IsNil)
+	}
+	expect, err := ms.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(buf.Bytes(), jc.DeepEquals, expect)
+
+	d := macaroon.NewDecoder(&buf)
+	for _, m := range ms {
+		m1, err := d.Decode()
+		c.Assert(err, gc.IsNil)
+		c.Assert(m1, jc.DeepEquals, m)
+	}
+	_, err = d.Decode()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (*encodeSuite) TestEncodeBase64Lines(c *gc.C) {
+	ms := decodeTestMacaroons(c)
+	var buf bytes.Buffer
+	e := macaroon.NewEncoder(&buf, macaroon.Base64LinesFormat)
+	for _, m := range ms {
+		err := e.Encode(m)
+		c.Assert(err, gc.IsNil)
+	}
+	scanner := bufio.NewScanner(&buf)
+	var got macaroon.Slice
+	for scanner.Scan() {
+		var m macaroon.Macaroon
+		err := m.UnmarshalText(scanner.Bytes())
+		c.Assert(err, gc.IsNil)
+		got = append(got, &m)
+	}
+	c.Assert(scanner.Err(), gc.IsNil)
+	c.Assert(got, jc.DeepEquals, ms)
+}
+
+func (*encodeSuite) TestEncodeJSONLines(c *gc.C) {
+	ms := decodeTestMacaroons(c)
+	var buf bytes.Buffer
+	e := macaroon.NewEncoder(&buf, macaroon.JSONLinesFormat)
+	e.SetJSONEncoder(macaroon.JSONEncoder{
+		BinaryEncoding: macaroon.JSONHex,
+	})
+	for _, m := range ms {
+		err := e.Encode(m)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(bytes.Count(buf.Bytes(), []byte("\n")), gc.Equals, len(ms))
+	c.Assert(bytes.Contains(buf.Bytes(), []byte(`"sH":`)), gc.Equals, true)
+
+	d := json.NewDecoder(&buf)
+	var got macaroon.Slice
+	for d.More() {
+		var m macaroon.Macaroon
+		err := d.Decode(&m)
+		c.Assert(err, gc.IsNil)
+		got = append(got, &m)
+	}
+	c.Assert(got, jc.DeepEquals, ms)
+}
+
+func (*encodeSuite) TestEncodeError(c *gc.C) {
+	e := macaroon.NewEncoder(&bytes.Buffer{}, macaroon.BinaryFormat)
+	err := e.Encode(&macaroon.Macaroon{})
+	c.Assert(err, gc.ErrorMatches, `cannot encode macaroon "": bad macaroon version v0`)
+
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	e = macaroon.NewEncoder(&bytes.Buffer{}, 99)
+	err = e.Encode(m)
+	c.Assert(err, gc.ErrorMatches, `cannot encode macaroon "some id": unknown encoder format 99`)
+
+	e = macaroon.NewEncoder(errorWriter{}, macaroon.BinaryFormat)
+	err = e.Encode(m)
+	c.Assert(err, gc.ErrorMatches, `write error`)
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("write error")
+}
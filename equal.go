@@ -0,0 +1,91 @@
+package macaroon
+
+import (
+	"bytes"
+	"crypto/hmac"
+)
+
+// Equal reports whether m and m1 represent the same macaroon,
+// comparing the location, identifier, caveats, signature and any
+// fields not understood by this package. The signatures are
+// compared in constant time. The version is not compared because
+// it only determines the encoding format.
+//
+// Two nil macaroons are considered equal.
+func (m *Macaroon) Equal(m1 *Macaroon) bool {
+	return m.equal(m1, false)
+}
+
+// EqualIgnoringLocations is like Equal except that it ignores
+// the location hints of the macaroon and its caveats, which are
+// not covered by the signature.
+func (m *Macaroon) EqualIgnoringLocations(m1 *Macaroon) bool {
+	return m.equal(m1, true)
+}
+
+func (m *Macaroon) equal(m1 *Macaroon, ignoreLocations bool) bool {
+	if m == nil || m1 == nil {
+		return m == m1
+	}
+	// Compare the signatures first as they're
+	// most likely to differ.
+	if !hmac.Equal(m.sig[:], m1.sig[:]) {
+		return false
+	}
+	if !bytes.Equal(m.id, m1.id) ||
+		!equalFields(m.extra, m1.extra) ||
+		len(m.caveats) != len(m1.caveats) {
+		return false
+	}
+	if !ignoreLocations && m.location != m1.location {
+		return false
+	}
+	for i, cav := range m.caveats {
+		cav1 := m1.caveats[i]
+		if !bytes.Equal(cav.Id, cav1.Id) ||
+			!bytes.Equal(cav.VerificationId, cav1.VerificationId) ||
+			!equalFields(cav.Extra, cav1.Extra) {
+			return false
+		}
+		if !ignoreLocations && cav.Location != cav1.Location {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFields(f0, f1 []Field) bool {
+	if len(f0) != len(f1) {
+		return false
+	}
+	for i := range f0 {
+		if f0[i].Type != f1[i].Type || !bytes.Equal(f0[i].Data, f1[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal reports whether s and s1 hold the same macaroons
+// in the same order, as compared by Macaroon.Equal.
+func (s Slice) Equal(s1 Slice) bool {
+	return s.equal(s1, false)
+}
+
+// EqualIgnoringLocations is like Equal except that the macaroons
+// are compared with Macaroon.EqualIgnoringLocations.
+func (s Slice) EqualIgnoringLocations(s1 Slice) bool {
+	return s.equal(s1, true)
+}
+
+func (s Slice) equal(s1 Slice, ignoreLocations bool) bool {
+	if len(s) != len(s1) {
+		return false
+	}
+	for i, m := range s {
+		if !m.equal(s1[i], ignoreLocations) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package macaroon_test
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type equalSuite struct{}
+
+var _ = gc.Suite(&equalSuite{})
+
+// equalTestMacaroon returns a macaroon created with the given
+// parameters, with a first party caveat and a third party caveat.
+func equalTestMacaroon(c *gc.C, rootKey, loc, cav, tpLoc string) *macaroon.Macaroon {
+	m := MustNew([]byte(rootKey), []byte("some id"), loc, macaroon.V2)
+	err := m.AddFirstPartyCaveat(cav)
+	c.Assert(err, gc.IsNil)
+	err = m.AddThirdPartyCaveatWithRand([]byte("3rd party key"), []byte("3rd party caveat"), tpLoc, zeroReader{})
+	c.Assert(err, gc.IsNil)
+	return m
+}
+
+var equalTests = []struct {
+	about                       string
+	rootKey, loc, cav, tpLoc    string
+	expectEqual                 bool
+	expectEqualIgnoringLocation bool
+}{{
+	about:                       "identical",
+	rootKey:                     "secret",
+	loc:                         "loc",
+	cav:                         "a caveat",
+	tpLoc:                       "remote",
+	expectEqual:                 true,
+	expectEqualIgnoringLocation: true,
+}, {
+	about:                       "different location",
+	rootKey:                     "secret",
+	loc:                         "other loc",
+	cav:                         "a caveat",
+	tpLoc:                       "remote",
+	expectEqualIgnoringLocation: true,
+}, {
+	about:                       "different caveat location",
+	rootKey:                     "secret",
+	loc:                         "loc",
+	cav:                         "a caveat",
+	tpLoc:                       "other remote",
+	expectEqualIgnoringLocation: true,
+}, {
+	about:   "different caveat",
+	rootKey: "secret",
+	loc:     "loc",
+	cav:     "another caveat",
+	tpLoc:   "remote",
+}, {
+	about:   "different signature",
+	rootKey: "other secret",
+	loc:     "loc",
+	cav:     "a caveat",
+	tpLoc:   "remote",
+}}
+
+func (*equalSuite) TestEqual(c *gc.C) {
+	m0 := equalTestMacaroon(c, "secret", "loc", "a caveat", "remote")
+	for i, test := range equalTests {
+		c.Logf("test %d: %s", i, test.about)
+		m1 := equalTestMacaroon(c, test.rootKey, test.loc, test.cav, test.tpLoc)
+		c.Assert(m0.Equal(m1), gc.Equals, test.expectEqual)
+		c.Assert(m1.Equal(m0), gc.Equals, test.expectEqual)
+		c.Assert(m0.EqualIgnoringLocations(m1), gc.Equals, test.expectEqualIgnoringLocation)
+
+		s0 := macaroon.Slice{m0, m0}
+		s1 := macaroon.Slice{m0, m1}
+		c.Assert(s0.Equal(s1), gc.Equals, test.expectEqual)
+		c.Assert(s0.EqualIgnoringLocations(s1), gc.Equals, test.expectEqualIgnoringLocation)
+	}
+}
+
+func (*equalSuite) TestEqualIgnoresVersion(c *gc.C) {
+	m0 := equalTestMacaroon(c, "secret", "loc", "a caveat", "remote")
+	m1, err := m0.ConvertTo(macaroon.V1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m0.Equal(m1), gc.Equals, true)
+
+	data, err := m1.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	var m2 macaroon.Macaroon
+	err = m2.UnmarshalJSON(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m0.Equal(&m2), gc.Equals, true)
+}
+
+func (*equalSuite) TestEqualNil(c *gc.C) {
+	m := equalTestMacaroon(c, "secret", "loc", "a caveat", "remote")
+	var nilm *macaroon.Macaroon
+	c.Assert(nilm.Equal(nil), gc.Equals, true)
+	c.Assert(m.Equal(nil), gc.Equals, false)
+	c.Assert(nilm.Equal(m), gc.Equals, false)
+
+	c.Assert(macaroon.Slice{}.Equal(nil), gc.Equals, true)
+	c.Assert(macaroon.Slice{m}.Equal(macaroon.Slice{m, m}), gc.Equals, false)
+}
+
+// zeroReader is an io.Reader that reads zero bytes, so that
+// third party caveats can be created deterministically.
+type zeroReader struct{}
+
+func (zeroReader) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	return len(buf), nil
+}
@@ -1,8 +1,7 @@
 package macaroon
 
 var (
-	AddThirdPartyCaveatWithRand = (*Macaroon).addThirdPartyCaveatWithRand
-	MaxPacketV1Len              = maxPacketV1Len
+	MaxPacketV1Len = maxPacketV1Len
 )
 
 // SetVersion sets the version field of m to v;
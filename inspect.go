@@ -0,0 +1,162 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Inspect returns a human-readable representation of the macaroon
+// in the same format as that printed by the libmacaroons inspect
+// function. Each field is printed on its own line, prefixed by its
+// libmacaroons field name. Third party caveats are those with "vid"
+// and "cl" lines following their "cid" line.
+//
+// Fields that are not printable UTF-8 text (which is
+// possible for V2 macaroons) are printed as Go-quoted
+// strings with non-printable bytes escaped. The verification
+// ids are printed in URL-safe base64 and the signature in hex.
+func (m *Macaroon) Inspect() string {
+	var buf bytes.Buffer
+	m.inspect(&buf, false)
+	return buf.String()
+}
+
+// inspect writes the inspection output for m to buf. If verbose
+// is true, it also writes comment lines describing the macaroon's
+// version and the type of each caveat. A nil macaroon is
+// written as "<nil>".
+func (m *Macaroon) inspect(buf *bytes.Buffer, verbose bool) {
+	if m == nil {
+		buf.WriteString("<nil>\n")
+		return
+	}
+	if verbose {
+		fmt.Fprintf(buf, "# version %v\n", m.version)
+	}
+	fmt.Fprintf(buf, "%s %s\n", fieldNameLocation, inspectValue([]byte(m.location)))
+	fmt.Fprintf(buf, "%s %s\n", fieldNameIdentifier, inspectValue(m.id))
+	for i, cav := range m.caveats {
+		if verbose {
+			kind := "first party"
+			if cav.isThirdParty() {
+				kind = "third party"
+			}
+			fmt.Fprintf(buf, "# caveat %d: %s\n", i, kind)
+		}
+		fmt.Fprintf(buf, "%s %s\n", fieldNameCaveatId, inspectValue(cav.Id))
+		if !cav.isThirdParty() {
+			continue
+		}
+		fmt.Fprintf(buf, "%s %s\n", fieldNameVerificationId, base64.RawURLEncoding.EncodeToString(cav.VerificationId))
+		fmt.Fprintf(buf, "%s %s\n", fieldNameCaveatLocation, inspectValue([]byte(cav.Location)))
+	}
+	fmt.Fprintf(buf, "%s %s\n", fieldNameSignature, hex.EncodeToString(m.sig[:]))
+}
+
+// Format implements fmt.Formatter. The %v and %s verbs
+// print the output of Inspect; the %+v verb also prints
+// the macaroon's version and annotates each caveat with its
+// type. The %q verb prints the Inspect output as a quoted string
+// and %#v prints a Go-syntax representation of the macaroon.
+func (m *Macaroon) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		if m == nil {
+			fmt.Fprintf(f, "(*macaroon.Macaroon)(nil)")
+			return
+		}
+		fmt.Fprintf(f, "&%#v", *m)
+		return
+	case verb != 'v' && verb != 's' && verb != 'q':
+		fmt.Fprintf(f, "%%!%c(*macaroon.Macaroon)", verb)
+		return
+	case m == nil:
+		fmt.Fprintf(f, "<nil>")
+		return
+	}
+	var buf bytes.Buffer
+	m.inspect(&buf, verb == 'v' && f.Flag('+'))
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", buf.String())
+		return
+	}
+	f.Write(buf.Bytes())
+}
+
+// Inspect returns a human-readable representation of all the
+// macaroons in the slice, each formatted as by Macaroon.Inspect.
+// Each macaroon is preceded by a comment line labeling it as
+// the primary macaroon (the first one) or as a discharge macaroon.
+func (s Slice) Inspect() string {
+	var buf bytes.Buffer
+	s.inspect(&buf, false)
+	return buf.String()
+}
+
+func (s Slice) inspect(buf *bytes.Buffer, verbose bool) {
+	for i, m := range s {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if i == 0 {
+			fmt.Fprintf(buf, "# primary\n")
+		} else {
+			fmt.Fprintf(buf, "# discharge %d\n", i)
+		}
+		m.inspect(buf, verbose)
+	}
+}
+
+// Format implements fmt.Formatter. It formats the slice
+// in the same way as Macaroon.Format, labeling
+// each macaroon as by Inspect.
+func (s Slice) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprintf(f, "%#v", []*Macaroon(s))
+		return
+	case verb != 'v' && verb != 's' && verb != 'q':
+		fmt.Fprintf(f, "%%!%c(macaroon.Slice)", verb)
+		return
+	}
+	var buf bytes.Buffer
+	s.inspect(&buf, verb == 'v' && f.Flag('+'))
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", buf.String())
+		return
+	}
+	f.Write(buf.Bytes())
+}
+
+// inspectValue returns a printable representation of the given
+// field data. Printable UTF-8 text is returned unchanged;
+// anything else is returned as a Go-quoted string.
+func inspectValue(data []byte) string {
+	if isPrintable(data) {
+		return string(data)
+	}
+	return strconv.Quote(string(data))
+}
+
+// isPrintable reports whether data holds valid UTF-8 text
+// that can be printed verbatim on a single inspection line
+// without being confused with a quoted value.
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	if len(data) > 0 && data[0] == '"' {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package macaroon_test
+
+import (
+	"fmt"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type inspectSuite struct{}
+
+var _ = gc.Suite(&inspectSuite{})
+
+// libMacaroonsBinary holds the base64-encoded V1 binary encoding
+// of the macaroon created in the README of the libmacaroons documentation.
+const libMacaroonsBinary = "MDAxY2xvY2F0aW9uIGh0dHA6Ly9teWJhbmsvCjAwMmNpZGVudGlmaWVyIHdlIHVzZWQgb3VyIG90aGVyIHNlY3JldCBrZXkKMDAxZGNpZCBhY2NvdW50ID0gMzczNTkyODU1OQowMDMwY2lkIHRoaXMgd2FzIGhvdyB3ZSByZW1pbmQgYXV0aCBvZiBrZXkvcHJlZAowMDUxdmlkIAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAANNuxQLgWIbR8CefBV-lJVTRbRbBsUB0u7g_8P3XncL-CY8O1KKwkRMOa120aiCoawowMDFiY2wgaHR0cDovL2F1dGgubXliYW5rLwowMDJmc2lnbmF0dXJlINJ9sv0fInYOTD2ugTfi2Pwd9sB0HBiu1LlyVr940fVcCg"
+
+const libMacaroonsInspect = `location http://mybank/
+identifier we used our other secret key
+cid account = 3735928559
+cid this was how we remind auth of key/pred
+vid AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD_w_dedwv4Jjw7UorCREw5rXbRqIKhr
+cl http://auth.mybank/
+signature d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c
+`
+
+func libMacaroonsMacaroon(c *gc.C) *macaroon.Macaroon {
+	var m macaroon.Macaroon
+	err := m.UnmarshalBinary(decodeB64(libMacaroonsBinary))
+	c.Assert(err, gc.IsNil)
+	return &m
+}
+
+func (*inspectSuite) TestInspect(c *gc.C) {
+	m := libMacaroonsMacaroon(c)
+	c.Assert(m.Inspect(), gc.Equals, libMacaroonsInspect)
+	c.Assert(fmt.Sprint(m), gc.Equals, libMacaroonsInspect)
+	c.Assert(fmt.Sprintf("%s", m), gc.Equals, libMacaroonsInspect)
+	c.Assert(fmt.Sprintf("%q", m), gc.Equals, fmt.Sprintf("%q", libMacaroonsInspect))
+}
+
+func (*inspectSuite) TestInspectVerbose(c *gc.C) {
+	m := libMacaroonsMacaroon(c)
+	c.Assert(fmt.Sprintf("%+v", m), gc.Equals, `# version v1
+location http://mybank/
+identifier we used our other secret key
+# caveat 0: first party
+cid account = 3735928559
+# caveat 1: third party
+cid this was how we remind auth of key/pred
+vid AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD_w_dedwv4Jjw7UorCREw5rXbRqIKhr
+cl http://auth.mybank/
+signature d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c
+`)
+}
+
+func (*inspectSuite) TestInspectBinaryFields(c *gc.C) {
+	m := MustNew([]byte("key"), []byte("\xff\x00id"), "", macaroon.V2)
+	err := m.AddFirstPartyCaveat("multi\nline")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Inspect(), gc.Equals, "location \n"+fmt.Sprintf(`identifier "\xff\x00id"
+cid "multi\nline"
+signature %x
+`, m.Signature()))
+}
+
+func (*inspectSuite) TestInspectBadVerb(c *gc.C) {
+	m := libMacaroonsMacaroon(c)
+	c.Assert(fmt.Sprintf("%d", m), gc.Equals, "%!d(*macaroon.Macaroon)")
+	c.Assert(fmt.Sprintf("%d", macaroon.Slice{m}), gc.Equals, "%!d(macaroon.Slice)")
+}
+
+func (*inspectSuite) TestInspectSlice(c *gc.C) {
+	m := libMacaroonsMacaroon(c)
+	d := MustNew([]byte("key"), []byte("this was how we remind auth of key/pred"), "http://auth.mybank/", macaroon.V1)
+	d.Bind(m.Signature())
+	s := macaroon.Slice{m, d}
+	expect := fmt.Sprintf(`# primary
+%s
+# discharge 1
+location http://auth.mybank/
+identifier this was how we remind auth of key/pred
+signature %x
+`, libMacaroonsInspect, d.Signature())
+	c.Assert(s.Inspect(), gc.Equals, expect)
+	c.Assert(fmt.Sprint(s), gc.Equals, expect)
+}
+
+func (*inspectSuite) TestInspectNil(c *gc.C) {
+	var m *macaroon.Macaroon
+	c.Assert(m.Inspect(), gc.Equals, "<nil>\n")
+	d := MustNew([]byte("key"), []byte("id"), "loc", macaroon.V2)
+	s := macaroon.Slice{nil, d}
+	expect := fmt.Sprintf(`# primary
+<nil>
+
+# discharge 1
+location loc
+identifier id
+signature %x
+`, d.Signature())
+	c.Assert(s.Inspect(), gc.Equals, expect)
+	c.Assert(fmt.Sprint(s), gc.Equals, expect)
+	c.Assert(fmt.Sprintf("%+v", s), gc.Matches, `# primary\n<nil>\n\n# discharge 1\n# version v2\n(.|\n)*`)
+}
@@ -0,0 +1,292 @@
+package macaroon
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// UnmarshalOptions holds limits that are enforced when
+// unmarshaling macaroons, to defend against hostile input.
+// A zero value for any limit means that the
+// corresponding quantity is unlimited.
+//
+// It also holds options that control how strictly the
+// binary encoding is checked.
+type UnmarshalOptions struct {
+	// MaxSize holds the maximum total size in bytes of the
+	// encoded data.
+	MaxSize int
+
+	// MaxCaveats holds the maximum number of caveats
+	// in any one macaroon.
+	MaxCaveats int
+
+	// MaxFieldLen holds the maximum length in bytes of any
+	// decoded field: the location, identifier, any caveat
+	// id, verification id or caveat location, or any field
+	// not understood by this package.
+	MaxFieldLen int
+
+	// MaxSliceLen holds the maximum number of
+	// macaroons in a Slice.
+	MaxSliceLen int
+
+	// RequireCanonical specifies that binary-encoded macaroons,
+	// including those inside base64-encoded JSON strings, must be
+	// in canonical form as produced by MarshalBinary. Encodings
+	// with non-minimal varints, empty location or verification id
+	// fields or trailing data are rejected, so each macaroon
+	// has exactly one accepted encoding. See also Canonical.
+	RequireCanonical bool
+
+	// StrictJSON specifies that JSON-encoded macaroons must
+	// contain only the fields defined by exactly one of the V1
+	// or V2 JSON formats, with no duplicate fields and no
+	// variation in the case of field names, and that V1
+	// signatures must be in lower case hex.
+	StrictJSON bool
+}
+
+// DefaultUnmarshalOptions holds the limits used by the
+// UnmarshalBinary, UnmarshalJSON and UnmarshalText methods
+// on Macaroon and Slice, and by Decoder.
+var DefaultUnmarshalOptions = UnmarshalOptions{
+	MaxSize:     1024 * 1024,
+	MaxCaveats:  1000,
+	MaxFieldLen: 64 * 1024,
+	MaxSliceLen: 100,
+}
+
+// LimitError is the error returned when unmarshaling data
+// that exceeds one of the limits in UnmarshalOptions.
+type LimitError struct {
+	// Limit holds the name of the UnmarshalOptions field
+	// holding the limit that was exceeded, for example "MaxCaveats".
+	Limit string
+
+	// Max holds the value of the limit.
+	Max int
+}
+
+func (e *LimitError) Error() string {
+	switch e.Limit {
+	case "MaxSize":
+		return fmt.Sprintf("macaroon data too large (limit %d bytes)", e.Max)
+	case "MaxCaveats":
+		return fmt.Sprintf("too many caveats (limit %d)", e.Max)
+	case "MaxFieldLen":
+		return fmt.Sprintf("macaroon field too long (limit %d bytes)", e.Max)
+	case "MaxSliceLen":
+		return fmt.Sprintf("too many macaroons (limit %d)", e.Max)
+	}
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// UnmarshalMacaroonBinary unmarshals the binary-encoded macaroon
+// in data into m, as Macaroon.UnmarshalBinary does, but
+// enforcing the limits in o.
+func (o *UnmarshalOptions) UnmarshalMacaroonBinary(m *Macaroon, data []byte) error {
+	// Copy the data to avoid retaining references to it
+	// in the internal data structures.
+	return o.parseMacaroon(m, append([]byte(nil), data...))
+}
+
+// UnmarshalMacaroonJSON unmarshals the JSON-encoded macaroon
+// in data into m, as Macaroon.UnmarshalJSON does, but
+// enforcing the limits in o.
+func (o *UnmarshalOptions) UnmarshalMacaroonJSON(m *Macaroon, data []byte) error {
+	if err := o.checkSize(len(data)); err != nil {
+		return err
+	}
+	if err := m.unmarshalJSON(data, o); err != nil {
+		return err
+	}
+	return o.checkMacaroon(m)
+}
+
+// UnmarshalSliceBinary unmarshals the binary-encoded macaroons
+// in data into s, as Slice.UnmarshalBinary does, but
+// enforcing the limits in o.
+func (o *UnmarshalOptions) UnmarshalSliceBinary(s *Slice, data []byte) error {
+	// Prevent the internal data structures from holding onto the
+	// slice by copying it first.
+	return o.parseSlice(s, append([]byte(nil), data...))
+}
+
+// UnmarshalSliceJSON unmarshals the JSON-encoded macaroons
+// in data into s, as Slice.UnmarshalJSON does, but
+// enforcing the limits in o.
+func (o *UnmarshalOptions) UnmarshalSliceJSON(s *Slice, data []byte) error {
+	if err := o.checkSize(len(data)); err != nil {
+		return err
+	}
+	if len(data) > 0 && data[0] == '"' {
+		// It's a string, so it must be a base64-encoded binary form.
+		data, err := unmarshalBase64JSON(data)
+		if err != nil {
+			return err
+		}
+		return o.parseSlice(s, data)
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return err
+	}
+	if err := o.checkSliceLen(len(elems)); err != nil {
+		return err
+	}
+	ms := make(Slice, len(elems))
+	for i, elem := range elems {
+		if string(elem) == "null" {
+			return fmt.Errorf("null macaroon at index %d", i)
+		}
+		var m Macaroon
+		if err := m.unmarshalJSON(elem, o); err != nil {
+			return err
+		}
+		if err := o.checkMacaroon(&m); err != nil {
+			return err
+		}
+		ms[i] = &m
+	}
+	*s = ms
+	return nil
+}
+
+// parseMacaroon parses the binary-encoded macaroon in data
+// into m, enforcing the limits in o. It retains references
+// to data.
+func (o *UnmarshalOptions) parseMacaroon(m *Macaroon, data []byte) error {
+	if err := o.checkSize(len(data)); err != nil {
+		return err
+	}
+	rest, err := m.parseBinary(data, o)
+	if err != nil {
+		return err
+	}
+	if o.RequireCanonical {
+		if len(rest) > 0 {
+			return fmt.Errorf("unexpected data after macaroon")
+		}
+		if err := m.checkCanonical(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSlice parses the binary-encoded macaroons in data
+// into s, enforcing the limits in o. It retains references
+// to data.
+func (o *UnmarshalOptions) parseSlice(s *Slice, data []byte) error {
+	if err := o.checkSize(len(data)); err != nil {
+		return err
+	}
+	*s = (*s)[:0]
+	for len(data) > 0 {
+		if err := o.checkSliceLen(len(*s) + 1); err != nil {
+			return err
+		}
+		var m Macaroon
+		rest, err := m.parseBinary(data, o)
+		if _, ok := err.(*LimitError); ok {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal macaroon: %v", err)
+		}
+		if o.RequireCanonical {
+			if err := m.checkCanonical(data[0 : len(data)-len(rest)]); err != nil {
+				return fmt.Errorf("cannot unmarshal macaroon: %v", err)
+			}
+		}
+		*s = append(*s, &m)
+		data = rest
+	}
+	return nil
+}
+
+// checkMacaroon checks that m is within the caveat
+// and field length limits in o. Binary-encoded macaroons
+// are checked as they are parsed, so this is only needed
+// for other encodings.
+func (o *UnmarshalOptions) checkMacaroon(m *Macaroon) error {
+	if err := o.checkCaveatCount(len(m.caveats)); err != nil {
+		return err
+	}
+	if o.MaxFieldLen <= 0 {
+		return nil
+	}
+	if err := o.checkFieldLen(len(m.location)); err != nil {
+		return err
+	}
+	if err := o.checkFieldLen(len(m.id)); err != nil {
+		return err
+	}
+	if err := o.checkExtraLen(m.extra); err != nil {
+		return err
+	}
+	for _, cav := range m.caveats {
+		if err := o.checkFieldLen(len(cav.Id)); err != nil {
+			return err
+		}
+		if err := o.checkFieldLen(len(cav.VerificationId)); err != nil {
+			return err
+		}
+		if err := o.checkFieldLen(len(cav.Location)); err != nil {
+			return err
+		}
+		if err := o.checkExtraLen(cav.Extra); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *UnmarshalOptions) checkExtraLen(fields []Field) error {
+	for _, f := range fields {
+		if err := o.checkFieldLen(len(f.Data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSectionV2 checks that the lengths of the fields
+// in the given V2 section are within the limits in o.
+func (o *UnmarshalOptions) checkSectionV2(section []packetV2) error {
+	for _, p := range section {
+		if err := o.checkFieldLen(len(p.data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *UnmarshalOptions) checkCaveatCount(n int) error {
+	if o.MaxCaveats > 0 && n > o.MaxCaveats {
+		return &LimitError{"MaxCaveats", o.MaxCaveats}
+	}
+	return nil
+}
+
+func (o *UnmarshalOptions) checkSize(n int) error {
+	if o.MaxSize > 0 && n > o.MaxSize {
+		return &LimitError{"MaxSize", o.MaxSize}
+	}
+	return nil
+}
+
+func (o *UnmarshalOptions) checkFieldLen(n int) error {
+	if o.MaxFieldLen > 0 && n > o.MaxFieldLen {
+		return &LimitError{"MaxFieldLen", o.MaxFieldLen}
+	}
+	return nil
+}
+
+func (o *UnmarshalOptions) checkSliceLen(n int) error {
+	if o.MaxSliceLen > 0 && n > o.MaxSliceLen {
+		return &LimitError{"MaxSliceLen", o.MaxSliceLen}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package macaroon_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type limitsSuite struct{}
+
+var _ = gc.Suite(&limitsSuite{})
+
+var limitsTests = []struct {
+	about       string
+	opts        macaroon.UnmarshalOptions
+	id          string
+	location    string
+	caveats     []string
+	expectLimit string
+	expectError string
+}{{
+	about: "within all limits",
+	opts: macaroon.UnmarshalOptions{
+		MaxSize:     1000,
+		MaxCaveats:  2,
+		MaxFieldLen: 10,
+	},
+	id:       "0123456789",
+	location: "loc",
+	caveats:  []string{"a", "b"},
+}, {
+	about: "too many caveats",
+	opts: macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	},
+	id:          "some id",
+	caveats:     []string{"a", "b", "c"},
+	expectLimit: "MaxCaveats",
+	expectError: `too many caveats \(limit 2\)`,
+}, {
+	about: "id too long",
+	opts: macaroon.UnmarshalOptions{
+		MaxFieldLen: 10,
+	},
+	id:          "0123456789a",
+	expectLimit: "MaxFieldLen",
+	expectError: `macaroon field too long \(limit 10 bytes\)`,
+}, {
+	about: "location too long",
+	opts: macaroon.UnmarshalOptions{
+		MaxFieldLen: 10,
+	},
+	id:          "some id",
+	location:    "0123456789a",
+	expectLimit: "MaxFieldLen",
+	expectError: `macaroon field too long \(limit 10 bytes\)`,
+}, {
+	about: "caveat too long",
+	opts: macaroon.UnmarshalOptions{
+		MaxFieldLen: 10,
+	},
+	id:          "some id",
+	caveats:     []string{"a", "0123456789a"},
+	expectLimit: "MaxFieldLen",
+	expectError: `macaroon field too long \(limit 10 bytes\)`,
+}, {
+	about: "data too large",
+	opts: macaroon.UnmarshalOptions{
+		MaxSize: 50,
+	},
+	id:          strings.Repeat("x", 50),
+	expectLimit: "MaxSize",
+	expectError: `macaroon data too large \(limit 50 bytes\)`,
+}}
+
+func (*limitsSuite) TestLimits(c *gc.C) {
+	for i, test := range limitsTests {
+		c.Logf("test %d: %s", i, test.about)
+		for _, vers := range []macaroon.Version{macaroon.V1, macaroon.V2} {
+			m := MustNew([]byte("secret"), []byte(test.id), test.location, vers)
+			for _, cav := range test.caveats {
+				err := m.AddFirstPartyCaveat(cav)
+				c.Assert(err, gc.IsNil)
+			}
+			binData, err := m.MarshalBinary()
+			c.Assert(err, gc.IsNil)
+			jsonData, err := m.MarshalJSON()
+			c.Assert(err, gc.IsNil)
+
+			var m1 macaroon.Macaroon
+			err = test.opts.UnmarshalMacaroonBinary(&m1, binData)
+			checkLimitError(c, err, test.expectLimit, test.expectError)
+
+			var m2 macaroon.Macaroon
+			err = test.opts.UnmarshalMacaroonJSON(&m2, jsonData)
+			checkLimitError(c, err, test.expectLimit, test.expectError)
+
+			var s1 macaroon.Slice
+			err = test.opts.UnmarshalSliceBinary(&s1, binData)
+			checkLimitError(c, err, test.expectLimit, test.expectError)
+
+			var s2 macaroon.Slice
+			err = test.opts.UnmarshalSliceJSON(&s2, []byte("["+string(jsonData)+"]"))
+			checkLimitError(c, err, test.expectLimit, test.expectError)
+
+			if test.expectError == "" {
+				c.Assert(&m1, jc.DeepEquals, m)
+				c.Assert(&m2, jc.DeepEquals, m)
+				c.Assert(s1, jc.DeepEquals, macaroon.Slice{m})
+				c.Assert(s2, jc.DeepEquals, macaroon.Slice{m})
+			}
+		}
+	}
+}
+
+func checkLimitError(c *gc.C, err error, expectLimit, expectError string) {
+	if expectError == "" {
+		c.Assert(err, gc.IsNil)
+		return
+	}
+	c.Assert(err, gc.ErrorMatches, expectError)
+	c.Assert(err, gc.FitsTypeOf, (*macaroon.LimitError)(nil))
+	c.Assert(err.(*macaroon.LimitError).Limit, gc.Equals, expectLimit)
+}
+
+func (*limitsSuite) TestSliceLenLimit(c *gc.C) {
+	opts := macaroon.UnmarshalOptions{
+		MaxSliceLen: 2,
+	}
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	ms := macaroon.Slice{m, m, m}
+	binData, err := ms.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	jsonData, err := json.Marshal(ms)
+	c.Assert(err, gc.IsNil)
+
+	var s macaroon.Slice
+	err = opts.UnmarshalSliceBinary(&s, binData)
+	checkLimitError(c, err, "MaxSliceLen", `too many macaroons \(limit 2\)`)
+	err = opts.UnmarshalSliceJSON(&s, jsonData)
+	checkLimitError(c, err, "MaxSliceLen", `too many macaroons \(limit 2\)`)
+
+	err = opts.UnmarshalSliceBinary(&s, binData[0:len(binData)*2/3])
+	c.Assert(err, gc.IsNil)
+	c.Assert(s, gc.HasLen, 2)
+}
+
+func (*limitsSuite) TestDefaultLimits(c *gc.C) {
+	maxCaveats := macaroon.DefaultUnmarshalOptions.MaxCaveats
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	for i := 0; i <= maxCaveats; i++ {
+		err := m.AddFirstPartyCaveat(fmt.Sprint(i))
+		c.Assert(err, gc.IsNil)
+	}
+	expectError := fmt.Sprintf(`too many caveats \(limit %d\)`, maxCaveats)
+
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.ErrorMatches, expectError)
+
+	var s macaroon.Slice
+	err = s.UnmarshalBinary(data)
+	c.Assert(err, gc.ErrorMatches, expectError)
+
+	text, err := m.MarshalText()
+	c.Assert(err, gc.IsNil)
+	err = m1.UnmarshalText(text)
+	c.Assert(err, gc.ErrorMatches, expectError)
+
+	jsonData, err := m.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	err = m1.UnmarshalJSON(jsonData)
+	c.Assert(err, gc.ErrorMatches, expectError)
+
+	_, err = macaroon.NewDecoder(bytes.NewReader(data)).Decode()
+	c.Assert(err, gc.ErrorMatches, expectError)
+}
+
+func (*limitsSuite) TestUnlimited(c *gc.C) {
+	var opts macaroon.UnmarshalOptions
+	m := MustNew([]byte("secret"), []byte(strings.Repeat("x", 100*1024)), "", macaroon.V2)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.ErrorMatches, `macaroon field too long \(limit 65536 bytes\)`)
+
+	err = opts.UnmarshalMacaroonBinary(&m1, data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m1, jc.DeepEquals, m)
+}
+
+var earlyLimitTests = []struct {
+	about       string
+	opts        macaroon.UnmarshalOptions
+	data        string
+	expectLimit string
+	expectError string
+}{{
+	about: "too many v2 caveats",
+	opts: macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	},
+	// A header followed by three caveats with empty
+	// ids and then data that cannot be parsed.
+	data:        "\x02\x02\x02id\x00" + "\x02\x00\x00\x02\x00\x00\x02\x00\x00" + "\xff\xff\xff",
+	expectLimit: "MaxCaveats",
+	expectError: `too many caveats \(limit 2\)`,
+}, {
+	about: "v2 caveat id too long",
+	opts: macaroon.UnmarshalOptions{
+		MaxFieldLen: 3,
+	},
+	data:        "\x02\x02\x02id\x00" + "\x02\x04abcd\x00" + "\xff\xff\xff",
+	expectLimit: "MaxFieldLen",
+	expectError: `macaroon field too long \(limit 3 bytes\)`,
+}, {
+	about: "v2 identifier too long",
+	opts: macaroon.UnmarshalOptions{
+		MaxFieldLen: 3,
+	},
+	data:        "\x02\x02\x04abcd\x00" + "\xff\xff\xff",
+	expectLimit: "MaxFieldLen",
+	expectError: `macaroon field too long \(limit 3 bytes\)`,
+}, {
+	about: "too many v1 caveats",
+	opts: macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	},
+	data:        "000elocation \n0010identifier \n" + "000acid a\n000acid b\n000acid c\n" + "zzzz",
+	expectLimit: "MaxCaveats",
+	expectError: `too many caveats \(limit 2\)`,
+}, {
+	about: "v1 caveat id too long",
+	opts: macaroon.UnmarshalOptions{
+		MaxFieldLen: 3,
+	},
+	data:        "000elocation \n0010identifier \n" + "000dcid abcd\n" + "zzzz",
+	expectLimit: "MaxFieldLen",
+	expectError: `macaroon field too long \(limit 3 bytes\)`,
+}}
+
+func (*limitsSuite) TestLimitsCheckedWhileParsing(c *gc.C) {
+	// Each test input is invalid after the point where the
+	// limit is exceeded, so if the whole input was parsed
+	// before the limits were checked, we'd see a different
+	// error.
+	for i, test := range earlyLimitTests {
+		c.Logf("test %d: %s", i, test.about)
+		var m macaroon.Macaroon
+		err := test.opts.UnmarshalMacaroonBinary(&m, []byte(test.data))
+		checkLimitError(c, err, test.expectLimit, test.expectError)
+
+		var s macaroon.Slice
+		err = test.opts.UnmarshalSliceBinary(&s, []byte(test.data))
+		checkLimitError(c, err, test.expectLimit, test.expectError)
+
+		// Check that the test data is really invalid.
+		var unlimited macaroon.UnmarshalOptions
+		err = unlimited.UnmarshalMacaroonBinary(&m, []byte(test.data))
+		c.Assert(err, gc.NotNil)
+		c.Assert(err, gc.Not(gc.FitsTypeOf), (*macaroon.LimitError)(nil))
+	}
+}
+
+func (*limitsSuite) TestManyEmptyCaveats(c *gc.C) {
+	// A megabyte of empty caveat sections is within the
+	// default size limit but should be rejected as soon as the
+	// caveat limit is reached, without building them all.
+	data := []byte("\x02\x02\x02id\x00")
+	data = append(data, bytes.Repeat([]byte("\x02\x00\x00"), 1024*1024/3-10)...)
+	var m macaroon.Macaroon
+	allocs := testing.AllocsPerRun(1, func() {
+		err := m.UnmarshalBinary(data)
+		checkLimitError(c, err, "MaxCaveats", `too many caveats \(limit 1000\)`)
+	})
+	// Building all the caveats would take hundreds of thousands
+	// of allocations; stopping at the limit takes a few thousand.
+	c.Assert(allocs < 10000, jc.IsTrue, gc.Commentf("%v allocations", allocs))
+}
+
+func (*limitsSuite) TestJSONCaveatLimit(c *gc.C) {
+	opts := macaroon.UnmarshalOptions{
+		MaxCaveats: 2,
+	}
+	for _, data := range []string{
+		`{"i":"id","s64":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA","c":[{"i":"a"},{"i":"b"},{"i":"c"}]}`,
+		`{"identifier":"id","signature":"","caveats":[{"cid":"a"},{"cid":"b"},{"cid":"c"}]}`,
+	} {
+		var m macaroon.Macaroon
+		// The invalid signatures would be reported if the
+		// caveat limit wasn't checked first.
+		err := opts.UnmarshalMacaroonJSON(&m, []byte(data))
+		checkLimitError(c, err, "MaxCaveats", `too many caveats \(limit 2\)`)
+	}
+}
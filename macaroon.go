@@ -20,13 +20,15 @@ import (
 // See Fig. 7 of http://theory.stanford.edu/~ataly/Papers/macaroons.pdf
 // for a description of the data contained within.
 // Macaroons are mutable objects - use Clone as appropriate
-// to avoid unwanted mutation.
+// to avoid unwanted mutation, or use Builder to create
+// Immutable macaroons that can be shared freely.
 type Macaroon struct {
 	location string
 	id       []byte
 	caveats  []Caveat
 	sig      [hashLen]byte
 	version  Version
+	extra    []Field
 }
 
 // Caveat holds a first person or third party caveat.
@@ -46,6 +48,28 @@ type Caveat struct {
 	// as part of the caveat, so should only
 	// be used as a hint.
 	Location string
+
+	// Extra holds any fields in the caveat that are not
+	// understood by this package, as found when parsing
+	// a V2 macaroon. They are preserved when the macaroon
+	// is marshaled in V2 format but, like Location, are
+	// not signature checked. Fields must be in ascending
+	// order of type.
+	Extra []Field
+}
+
+// Field holds a field of a V2 macaroon or caveat that
+// has a field type not understood by this package. Such fields
+// allow newer issuers to add information to macaroons
+// without breaking older implementations.
+type Field struct {
+	// Type holds the field type as encoded in the V2
+	// binary format. It must not be one of the field
+	// types understood by this package.
+	Type int
+
+	// Data holds the field's data.
+	Data []byte
 }
 
 // isThirdParty reports whether the caveat must be satisfied
@@ -62,7 +86,12 @@ func New(rootKey, id []byte, loc string, version Version) (*Macaroon, error) {
 		if !utf8.Valid(id) {
 			return nil, fmt.Errorf("invalid id for %v macaroon", id)
 		}
-		// TODO check id length too.
+		if err := checkPacketV1(fieldNameLocation, []byte(loc)); err != nil {
+			return nil, err
+		}
+		if err := checkPacketV1(fieldNameIdentifier, id); err != nil {
+			return nil, err
+		}
 	}
 	if version < V1 || version > LatestVersion {
 		return nil, fmt.Errorf("invalid version %v", version)
@@ -82,6 +111,9 @@ func (m *Macaroon) init(id []byte, loc string, vers Version) {
 }
 
 // Clone returns a copy of the receiving macaroon.
+// Adding caveats to the copy does not affect the
+// original, but the data within the caveats is shared
+// with it; use DeepClone to avoid that.
 func (m *Macaroon) Clone() *Macaroon {
 	m1 := *m
 	// Ensure that if any caveats are appended to the new
@@ -90,6 +122,82 @@ func (m *Macaroon) Clone() *Macaroon {
 	return &m1
 }
 
+// DeepClone returns a copy of the receiving macaroon
+// that shares no memory with it, so that the copy
+// is unaffected by any changes to the original
+// or to the data returned by its Caveats method.
+func (m *Macaroon) DeepClone() *Macaroon {
+	m1 := *m
+	m1.Compact()
+	return &m1
+}
+
+// Compact copies all the data held by the macaroon into
+// newly allocated memory. A macaroon unmarshaled from
+// binary data retains references to that data, so
+// Compact can be used to avoid keeping a large buffer
+// alive when only a small part of it is needed.
+//
+// After calling Compact, the macaroon shares no memory
+// with any macaroon it was cloned from.
+func (m *Macaroon) Compact() {
+	size := len(m.id) + fieldsSize(m.extra)
+	for _, cav := range m.caveats {
+		size += len(cav.Id) + len(cav.VerificationId) + fieldsSize(cav.Extra)
+	}
+	buf := make([]byte, 0, size)
+	m.id, buf = compactBytes(m.id, buf)
+	m.extra, buf = compactFields(m.extra, buf)
+	if m.caveats == nil {
+		return
+	}
+	caveats := make([]Caveat, len(m.caveats))
+	for i, cav := range m.caveats {
+		cav.Id, buf = compactBytes(cav.Id, buf)
+		cav.VerificationId, buf = compactBytes(cav.VerificationId, buf)
+		cav.Extra, buf = compactFields(cav.Extra, buf)
+		caveats[i] = cav
+	}
+	m.caveats = caveats
+}
+
+// compactBytes copies b to the end of buf, which must
+// have sufficient capacity, and returns the copy and
+// the extended buf. The distinction between nil and
+// empty is preserved.
+func compactBytes(b, buf []byte) ([]byte, []byte) {
+	if b == nil {
+		return nil, buf
+	}
+	start := len(buf)
+	buf = append(buf, b...)
+	return buf[start:len(buf):len(buf)], buf
+}
+
+// compactFields copies fields and their data, which is
+// stored in buf as for compactBytes.
+func compactFields(fields []Field, buf []byte) ([]Field, []byte) {
+	if fields == nil {
+		return nil, buf
+	}
+	fields1 := make([]Field, len(fields))
+	for i, f := range fields {
+		fields1[i].Type = f.Type
+		fields1[i].Data, buf = compactBytes(f.Data, buf)
+	}
+	return fields1, buf
+}
+
+// fieldsSize returns the total size of the
+// data in the given fields.
+func fieldsSize(fields []Field) int {
+	n := 0
+	for _, f := range fields {
+		n += len(f.Data)
+	}
+	return n
+}
+
 // Location returns the macaroon's location hint. This is
 // not verified as part of the macaroon.
 func (m *Macaroon) Location() string {
@@ -102,6 +210,13 @@ func (m *Macaroon) Id() []byte {
 	return append([]byte(nil), m.id...)
 }
 
+// Extra returns any fields in the macaroon's header that are
+// not understood by this package, as found when parsing a V2
+// macaroon. Like the location, these are not signature checked.
+func (m *Macaroon) Extra() []Field {
+	return m.extra[0:len(m.extra):len(m.extra)]
+}
+
 // Signature returns the macaroon's signature.
 func (m *Macaroon) Signature() []byte {
 	// sig := m.sig
@@ -132,7 +247,9 @@ func (m *Macaroon) addCaveat(caveatId, verificationId []byte, loc string) error
 		if !utf8.Valid(caveatId) {
 			return fmt.Errorf("invalid caveat id for %v macaroon", m.version)
 		}
-		// TODO check caveat length too.
+		if err := checkCaveatV1(caveatId, verificationId, loc); err != nil {
+			return err
+		}
 	}
 	m.appendCaveat(caveatId, verificationId, loc)
 	m.sig = *keyedHash2(&m.sig, verificationId, caveatId)
@@ -163,8 +280,16 @@ func (m *Macaroon) AddFirstPartyCaveat(condition string) error {
 	if !utf8.ValidString(condition) {
 		return fmt.Errorf("first party caveat condition is not a valid utf-8 string")
 	}
-	m.addCaveat([]byte(condition), nil, "")
-	return nil
+	return m.addCaveat([]byte(condition), nil, "")
+}
+
+// AddFirstPartyCaveatBytes is like AddFirstPartyCaveat except
+// that the condition may hold arbitrary binary data, allowing
+// compact binary-encoded conditions. For V1 macaroons, the
+// condition must still be valid UTF-8. Conditions added this
+// way may be checked with VerifyBytes.
+func (m *Macaroon) AddFirstPartyCaveatBytes(condition []byte) error {
+	return m.addCaveat(append([]byte(nil), condition...), nil, "")
 }
 
 // AddThirdPartyCaveat adds a third-party caveat to the macaroon,
@@ -174,19 +299,30 @@ func (m *Macaroon) AddFirstPartyCaveat(condition string) error {
 // or by holding a reference to it stored in the third party's
 // storage.
 func (m *Macaroon) AddThirdPartyCaveat(rootKey, caveatId []byte, loc string) error {
-	return m.addThirdPartyCaveatWithRand(rootKey, caveatId, loc, rand.Reader)
+	return m.AddThirdPartyCaveatWithRand(rootKey, caveatId, loc, rand.Reader)
 }
 
-// addThirdPartyCaveatWithRand adds a third-party caveat to the macaroon, using
-// the given source of randomness for encrypting the caveat id.
-func (m *Macaroon) addThirdPartyCaveatWithRand(rootKey, caveatId []byte, loc string, r io.Reader) error {
+// AddThirdPartyCaveatWithRand is like AddThirdPartyCaveat except
+// that it reads the nonce used to encrypt the caveat's
+// verification id from r instead of crypto/rand.Reader.
+// This makes it possible to create macaroons that are
+// exactly reproducible, for example in golden-file tests.
+//
+// Security note: r must only be something other than a
+// cryptographically secure random source in tests. If the same
+// nonce is used twice with the same macaroon signature,
+// an attacker who sees both macaroons may be able to learn
+// information about the third party caveat root keys and
+// forge verification ids.
+func (m *Macaroon) AddThirdPartyCaveatWithRand(rootKey, caveatId []byte, loc string, r io.Reader) error {
 	derivedKey := makeKey(rootKey)
 	verificationId, err := encrypt(&m.sig, derivedKey, r)
 	if err != nil {
 		return err
 	}
-	m.addCaveat(caveatId, verificationId, loc)
-	return nil
+	// Copy the caveat id so that the macaroon
+	// does not share it with the caller.
+	return m.addCaveat(append([]byte(nil), caveatId...), verificationId, loc)
 }
 
 var zeroKey [hashLen]byte
@@ -210,6 +346,17 @@ func bindForRequest(rootSig []byte, dischargeSig *[hashLen]byte) *[hashLen]byte
 //
 // Verify returns nil if the verification succeeds.
 func (m *Macaroon) Verify(rootKey []byte, check func(caveat string) error, discharges []*Macaroon) error {
+	return m.VerifyBytes(rootKey, func(caveat []byte) error {
+		return check(string(caveat))
+	}, discharges)
+}
+
+// VerifyBytes is like Verify except that the check function
+// is passed the first-party caveat condition as a byte slice,
+// which may hold non-UTF-8 data added with
+// AddFirstPartyCaveatBytes. The check function must not
+// retain or modify the slice.
+func (m *Macaroon) VerifyBytes(rootKey []byte, check func(caveat []byte) error, discharges []*Macaroon) error {
 	derivedKey := makeKey(rootKey)
 	// TODO(rog) consider distinguishing between classes of
 	// check error - some errors may be resolved by minting
@@ -232,7 +379,7 @@ func (m *Macaroon) Verify(rootKey []byte, check func(caveat string) error, disch
 	return nil
 }
 
-func (m *Macaroon) verify(rootSig *[hashLen]byte, rootKey *[hashLen]byte, check func(caveat string) error, discharges []*Macaroon, used []int) error {
+func (m *Macaroon) verify(rootSig *[hashLen]byte, rootKey *[hashLen]byte, check func(caveat []byte) error, discharges []*Macaroon, used []int) error {
 	caveatSig := keyedHash(rootKey, m.id)
 	for i, cav := range m.caveats {
 		if cav.isThirdParty() {
@@ -265,7 +412,7 @@ func (m *Macaroon) verify(rootSig *[hashLen]byte, rootKey *[hashLen]byte, check
 				return fmt.Errorf("cannot find discharge macaroon for caveat %x", cav.Id)
 			}
 		} else {
-			if err := check(string(cav.Id)); err != nil {
+			if err := check(cav.Id); err != nil {
 				return err
 			}
 		}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	jc "github.com/juju/testing/checkers"
@@ -89,7 +90,7 @@ func (*macaroonSuite) TestThirdPartyCaveatBadRandom(c *gc.C) {
 	dischargeRootKey := []byte("shared root key")
 	thirdPartyCaveatId := []byte("3rd party caveat")
 
-	err := macaroon.AddThirdPartyCaveatWithRand(m, dischargeRootKey, thirdPartyCaveatId, "remote.com", &macaroon.ErrorReader{})
+	err := m.AddThirdPartyCaveatWithRand(dischargeRootKey, thirdPartyCaveatId, "remote.com", &macaroon.ErrorReader{})
 	c.Assert(err, gc.ErrorMatches, "cannot generate random bytes: fail")
 }
 
@@ -782,6 +783,32 @@ func (*macaroonSuite) TestBinaryMarshalingAgainstLibmacaroon(c *gc.C) {
 	err = m1.UnmarshalJSON(jsonData)
 	c.Assert(err, gc.IsNil)
 	assertEqualMacaroons(c, &m0, &m1)
+
+	// It marshals back to exactly the same data.
+	data1, err := m0.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data1, jc.DeepEquals, data)
+
+	// Creating the same macaroon with the keys from the
+	// libmacaroons README gives the same signature, and it
+	// verifies with the discharge for its third party caveat.
+	rootKey := []byte("this is a different super-secret key; never use the same secret twice")
+	m2 := MustNew(rootKey, []byte("we used our other secret key"), "http://mybank/", macaroon.V1)
+	err = m2.AddFirstPartyCaveat("account = 3735928559")
+	c.Assert(err, gc.IsNil)
+	err = m2.AddThirdPartyCaveatWithRand([]byte("4; guaranteed random by a fair toss of the dice"), []byte("this was how we remind auth of key/pred"), "http://auth.mybank/", zeroReader{})
+	c.Assert(err, gc.IsNil)
+	assertEqualMacaroons(c, m2, &m0)
+
+	d := MustNew([]byte("4; guaranteed random by a fair toss of the dice"), []byte("this was how we remind auth of key/pred"), "http://auth.mybank/", macaroon.V1)
+	d.Bind(m0.Signature())
+	err = m0.Verify(rootKey, func(cond string) error {
+		if cond != "account = 3735928559" {
+			return fmt.Errorf("unexpected caveat %q", cond)
+		}
+		return nil
+	}, []*macaroon.Macaroon{d})
+	c.Assert(err, gc.IsNil)
 }
 
 func (*macaroonSuite) TestInvalidMacaroonFields(c *gc.C) {
@@ -792,3 +819,153 @@ func (*macaroonSuite) TestInvalidMacaroonFields(c *gc.C) {
 	err := m0.AddFirstPartyCaveat(badString)
 	c.Assert(err, gc.ErrorMatches, `first party caveat condition is not a valid utf-8 string`)
 }
+
+func (*macaroonSuite) TestNewV1TooLong(c *gc.C) {
+	rootKey := []byte("secret")
+	_, err := macaroon.New(rootKey, make([]byte, macaroon.MaxPacketV1Len), "", macaroon.V1)
+	c.Assert(err, gc.ErrorMatches, `identifier too long for v1 macaroon \(65535 bytes\)`)
+	_, err = macaroon.New(rootKey, []byte("some id"), strings.Repeat("x", macaroon.MaxPacketV1Len), macaroon.V1)
+	c.Assert(err, gc.ErrorMatches, `location too long for v1 macaroon \(65535 bytes\)`)
+
+	// V2 macaroons have no such restriction.
+	_, err = macaroon.New(rootKey, make([]byte, macaroon.MaxPacketV1Len), "", macaroon.V2)
+	c.Assert(err, gc.IsNil)
+}
+
+func (*macaroonSuite) TestAddCaveatV1TooLong(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.V1)
+	sig := m.Signature()
+
+	err := m.AddFirstPartyCaveat(strings.Repeat("x", macaroon.MaxPacketV1Len))
+	c.Assert(err, gc.ErrorMatches, `cid too long for v1 macaroon \(65535 bytes\)`)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), make([]byte, macaroon.MaxPacketV1Len), "remote.com")
+	c.Assert(err, gc.ErrorMatches, `cid too long for v1 macaroon \(65535 bytes\)`)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), strings.Repeat("x", macaroon.MaxPacketV1Len))
+	c.Assert(err, gc.ErrorMatches, `cl too long for v1 macaroon \(65535 bytes\)`)
+
+	// The macaroon is unchanged and still valid.
+	c.Assert(m.Signature(), jc.DeepEquals, sig)
+	c.Assert(m.Caveats(), gc.HasLen, 0)
+	err = m.Verify(rootKey, never, nil)
+	c.Assert(err, gc.IsNil)
+	_, err = m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+}
+
+func (*macaroonSuite) TestUnmarshalJSONV1TooLong(c *gc.C) {
+	data := fmt.Sprintf(`{"identifier":"%s","signature":"d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c"}`, strings.Repeat("x", macaroon.MaxPacketV1Len))
+	var m macaroon.Macaroon
+	err := json.Unmarshal([]byte(data), &m)
+	c.Assert(err, gc.ErrorMatches, `identifier too long for v1 macaroon \(65535 bytes\)`)
+}
+
+func (*macaroonSuite) TestDeepClone(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	err = m.AddThirdPartyCaveat([]byte("3rd party key"), []byte("3rd party caveat"), "remote")
+	c.Assert(err, gc.IsNil)
+
+	m1 := m.DeepClone()
+	c.Assert(m1, jc.DeepEquals, m)
+
+	// Changing the original's caveat data does
+	// not affect the deep clone.
+	m.Caveats()[0].Id[0] = 'x'
+	m.Caveats()[1].VerificationId[0] ^= 1
+	c.Assert(string(m1.Caveats()[0].Id), gc.Equals, "a caveat")
+	c.Assert(m1.Caveats()[1].VerificationId, gc.Not(jc.DeepEquals), m.Caveats()[1].VerificationId)
+
+	// Whereas it does affect an ordinary clone.
+	m2 := m.Clone()
+	m.Caveats()[0].Id[0] = 'y'
+	c.Assert(string(m2.Caveats()[0].Id), gc.Equals, "y caveat")
+}
+
+func (*macaroonSuite) TestCompact(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	m1.Compact()
+	c.Assert(&m1, jc.DeepEquals, m)
+
+	// Compacted macaroons can still be extended.
+	err = m1.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Caveats(), gc.HasLen, 2)
+	c.Assert(m.Caveats(), gc.HasLen, 1)
+
+	var m2 macaroon.Macaroon
+	m2.Compact()
+	c.Assert(&m2, jc.DeepEquals, &macaroon.Macaroon{})
+}
+
+func (*macaroonSuite) TestFirstPartyCaveatBytes(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.V2)
+	cond := []byte{0xff, 0x00, 0x01}
+	err := m.AddFirstPartyCaveatBytes(cond)
+	c.Assert(err, gc.IsNil)
+	cond[0] = 0
+	c.Assert(m.Caveats()[0].Id, jc.DeepEquals, []byte{0xff, 0x00, 0x01})
+
+	var checked [][]byte
+	err = m.VerifyBytes(rootKey, func(caveat []byte) error {
+		checked = append(checked, append([]byte(nil), caveat...))
+		return nil
+	}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, jc.DeepEquals, [][]byte{{0xff, 0x00, 0x01}})
+
+	err = m.VerifyBytes(rootKey, func(caveat []byte) error {
+		return fmt.Errorf("condition %x not met", caveat)
+	}, nil)
+	c.Assert(err, gc.ErrorMatches, `condition ff0001 not met`)
+
+	// The binary caveat survives a round trip.
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Caveats()[0].Id, jc.DeepEquals, []byte{0xff, 0x00, 0x01})
+}
+
+func (*macaroonSuite) TestFirstPartyCaveatBytesV1(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	err := m.AddFirstPartyCaveatBytes([]byte{0xff})
+	c.Assert(err, gc.ErrorMatches, `invalid caveat id for v1 macaroon`)
+	c.Assert(m.Caveats(), gc.HasLen, 0)
+
+	err = m.AddFirstPartyCaveatBytes([]byte("text"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Caveats(), gc.HasLen, 1)
+}
+
+func (*macaroonSuite) TestAddThirdPartyCaveatWithRandIsDeterministic(c *gc.C) {
+	newMacaroon := func() []byte {
+		m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+		r := strings.NewReader(strings.Repeat("x", 24))
+		err := m.AddThirdPartyCaveatWithRand([]byte("3rd party key"), []byte("3rd party caveat"), "remote", r)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(m.Caveats()[0].VerificationId[0:24]), gc.Equals, strings.Repeat("x", 24))
+		data, err := m.MarshalBinary()
+		c.Assert(err, gc.IsNil)
+		return data
+	}
+	c.Assert(newMacaroon(), jc.DeepEquals, newMacaroon())
+}
+
+func (*macaroonSuite) TestAddThirdPartyCaveatWithRandShortRead(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddThirdPartyCaveatWithRand([]byte("3rd party key"), []byte("3rd party caveat"), "remote", strings.NewReader("short"))
+	c.Assert(err, gc.ErrorMatches, `cannot generate random bytes: unexpected EOF`)
+	c.Assert(m.Caveats(), gc.HasLen, 0)
+}
@@ -0,0 +1,285 @@
+// Package macaroonhttp provides support for using macaroons
+// to authorize HTTP requests.
+//
+// A client sends macaroons to a server in the Authorization header,
+// as the word "Macaroon" followed by the base64 encoding of a
+// macaroon.Slice holding the primary macaroon and its discharges,
+// as produced by Slice.MarshalText. Macaroons may also be sent in
+// cookies whose names start with "macaroon-", each holding a
+// slice encoded in the same way.
+//
+// When authorization fails, the server may respond with a
+// DischargeRequiredResponse holding a new macaroon that the
+// client can discharge and use to retry the request; see
+// Transport for a client that does that.
+package macaroonhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+// DefaultCookiePrefix holds the prefix of the names of cookies
+// that hold macaroons when Params.CookiePrefix is empty.
+const DefaultCookiePrefix = "macaroon-"
+
+// authScheme holds the authentication scheme used in the
+// Authorization and WWW-Authenticate headers.
+const authScheme = "Macaroon"
+
+// ErrUnknownRootKey should be returned by Params.RootKey
+// when there is no root key for a macaroon.
+var ErrUnknownRootKey = errors.New("unknown root key")
+
+// DischargeRequiredResponse holds the JSON body of a response
+// with status http.StatusUnauthorized that contains a macaroon
+// that the client should discharge and use to retry the request.
+type DischargeRequiredResponse struct {
+	// Message holds a description of why authorization failed.
+	Message string `json:"message,omitempty"`
+
+	// Macaroon holds the macaroon to be discharged.
+	Macaroon *macaroon.Macaroon `json:"macaroon"`
+}
+
+// Params holds the parameters for NewHandler.
+type Params struct {
+	// RootKey returns the root key for the macaroon with the
+	// given id. It should return ErrUnknownRootKey, or an error
+	// that wraps it, if there is no such key; other errors cause
+	// the request to fail with an internal server error. It must
+	// be non-nil.
+	RootKey func(ctx context.Context, id []byte) ([]byte, error)
+
+	// Check checks that the given first party caveat
+	// condition holds for the given request. If it is nil,
+	// all first party caveats are rejected.
+	Check func(req *http.Request, condition string) error
+
+	// CookiePrefix holds the prefix of the names of cookies
+	// holding macaroons. If it is empty, DefaultCookiePrefix
+	// is used.
+	CookiePrefix string
+
+	// NewMacaroon, if non-nil, is called when authorization
+	// fails to create a new macaroon to return to the client in a
+	// DischargeRequiredResponse. If it is nil or returns an error,
+	// a plain unauthorized response is sent.
+	NewMacaroon func(req *http.Request) (*macaroon.Macaroon, error)
+
+	// ErrorLog, if non-nil, is used to log internal errors.
+	// The details of such errors are never sent to the client.
+	ErrorLog *log.Logger
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx holding the
+// given verified macaroons.
+func NewContext(ctx context.Context, ms macaroon.Slice) context.Context {
+	return context.WithValue(ctx, contextKey{}, ms)
+}
+
+// FromContext returns the verified macaroons stored in
+// ctx by the handler returned by NewHandler: the primary
+// macaroon followed by the discharges used to verify it.
+func FromContext(ctx context.Context) (macaroon.Slice, bool) {
+	ms, ok := ctx.Value(contextKey{}).(macaroon.Slice)
+	return ms, ok
+}
+
+// NewHandler returns a handler that calls h only for requests
+// that present macaroons that verify according to p. The
+// verified macaroons are available to h by calling FromContext
+// on the request context.
+//
+// If the request has no macaroons, or none of them verify, the
+// handler responds with http.StatusUnauthorized; if none of the
+// macaroons can be decoded, it responds with http.StatusBadRequest.
+//
+// NewHandler panics if p.RootKey is nil.
+func NewHandler(h http.Handler, p Params) http.Handler {
+	if p.RootKey == nil {
+		panic("macaroonhttp: NewHandler called with nil Params.RootKey")
+	}
+	if p.Check == nil {
+		p.Check = rejectAll
+	}
+	if p.CookiePrefix == "" {
+		p.CookiePrefix = DefaultCookiePrefix
+	}
+	return &handler{
+		handler: h,
+		p:       p,
+	}
+}
+
+// rejectAll is used as the caveat checker
+// when Params.Check is nil.
+func rejectAll(req *http.Request, condition string) error {
+	return fmt.Errorf("caveat %q not satisfied: no caveat checker", condition)
+}
+
+type handler struct {
+	handler http.Handler
+	p       Params
+}
+
+// verifyError is returned by verify when
+// the macaroons do not verify.
+type verifyError struct {
+	err error
+}
+
+func (e *verifyError) Error() string {
+	return e.err.Error()
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	slices, err := RequestMacaroons(req, h.p.CookiePrefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(slices) == 0 {
+		h.unauthorized(w, req, fmt.Errorf("no macaroons found in request"))
+		return
+	}
+	var verifyErr error
+	for _, ms := range slices {
+		err := h.verify(req, ms)
+		if err == nil {
+			h.handler.ServeHTTP(w, req.WithContext(NewContext(req.Context(), ms)))
+			return
+		}
+		if _, ok := err.(*verifyError); !ok {
+			h.internalError(w, err)
+			return
+		}
+		if verifyErr == nil {
+			verifyErr = err
+		}
+	}
+	h.unauthorized(w, req, verifyErr)
+}
+
+// verify verifies the macaroons in ms. If the macaroons
+// do not verify, it returns a *verifyError.
+func (h *handler) verify(req *http.Request, ms macaroon.Slice) error {
+	if len(ms) == 0 {
+		return &verifyError{fmt.Errorf("empty macaroon slice")}
+	}
+	rootKey, err := h.p.RootKey(req.Context(), ms[0].Id())
+	if errors.Is(err, ErrUnknownRootKey) {
+		return &verifyError{fmt.Errorf("cannot find root key for macaroon %q", ms[0].Id())}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get root key: %v", err)
+	}
+	err = ms[0].Verify(rootKey, func(condition string) error {
+		return h.p.Check(req, condition)
+	}, ms[1:])
+	if err != nil {
+		return &verifyError{err}
+	}
+	return nil
+}
+
+// unauthorized writes an unauthorized response, including
+// a new macaroon if possible.
+func (h *handler) unauthorized(w http.ResponseWriter, req *http.Request, authErr error) {
+	w.Header().Set("WWW-Authenticate", authScheme)
+	if h.p.NewMacaroon == nil {
+		http.Error(w, authErr.Error(), http.StatusUnauthorized)
+		return
+	}
+	m, err := h.p.NewMacaroon(req)
+	if err != nil {
+		http.Error(w, authErr.Error(), http.StatusUnauthorized)
+		return
+	}
+	data, err := json.Marshal(&DischargeRequiredResponse{
+		Message:  authErr.Error(),
+		Macaroon: m,
+	})
+	if err != nil {
+		h.internalError(w, fmt.Errorf("cannot marshal discharge-required response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(data)
+}
+
+// internalError logs err and writes an internal server error
+// response that does not reveal any details of it.
+func (h *handler) internalError(w http.ResponseWriter, err error) {
+	if h.p.ErrorLog != nil {
+		h.p.ErrorLog.Printf("macaroonhttp: %v", err)
+	}
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+// RequestMacaroons returns the macaroon slices found in the
+// Authorization header and in cookies with the given name prefix
+// in req. The slice from the Authorization header, if any, is
+// first.
+//
+// Values that cannot be decoded are skipped, so that a stale
+// cookie does not prevent the use of other macaroons. An error
+// is returned only if no macaroons could be decoded and at
+// least one value could not be.
+func RequestMacaroons(req *http.Request, cookiePrefix string) ([]macaroon.Slice, error) {
+	var slices []macaroon.Slice
+	var firstErr error
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		scheme, text := auth, ""
+		if i := strings.IndexByte(auth, ' '); i >= 0 {
+			scheme, text = auth[0:i], strings.TrimSpace(auth[i+1:])
+		}
+		if strings.EqualFold(scheme, authScheme) {
+			var ms macaroon.Slice
+			if err := ms.UnmarshalText([]byte(text)); err != nil {
+				firstErr = fmt.Errorf("invalid Authorization header: %v", err)
+			} else {
+				slices = append(slices, ms)
+			}
+		}
+	}
+	for _, cookie := range req.Cookies() {
+		if !strings.HasPrefix(cookie.Name, cookiePrefix) {
+			continue
+		}
+		var ms macaroon.Slice
+		if err := ms.UnmarshalText([]byte(cookie.Value)); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("invalid cookie %q: %v", cookie.Name, err)
+			}
+			continue
+		}
+		slices = append(slices, ms)
+	}
+	if len(slices) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return slices, nil
+}
+
+// SetAuthorization sets the Authorization header of req
+// to hold the given macaroons.
+func SetAuthorization(req *http.Request, ms macaroon.Slice) error {
+	text, err := ms.MarshalText()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authScheme+" "+string(text))
+	return nil
+}
@@ -0,0 +1,408 @@
+package macaroonhttp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+	"gopkg.in/macaroon.v2-unstable/macaroonhttp"
+	"gopkg.in/macaroon.v2-unstable/macaroontest"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type handlerSuite struct{}
+
+var _ = gc.Suite(&handlerSuite{})
+
+// testServer holds a server that requires macaroons
+// minted by its root key store.
+type testServer struct {
+	store *macaroontest.RootKeyStore
+	srv   *httptest.Server
+}
+
+func newTestServer(c *gc.C, p macaroonhttp.Params) *testServer {
+	ts := &testServer{
+		store: macaroontest.NewRootKeyStore(macaroontest.NewFixedRand([]byte("server"))),
+	}
+	if p.RootKey == nil {
+		p.RootKey = func(ctx context.Context, id []byte) ([]byte, error) {
+			rootKey, err := ts.store.Get(id)
+			if err == macaroontest.ErrNotFound {
+				return nil, macaroonhttp.ErrUnknownRootKey
+			}
+			return rootKey, err
+		}
+	}
+	if p.Check == nil {
+		p.Check = func(req *http.Request, condition string) error {
+			if condition != "path "+req.URL.Path {
+				return fmt.Errorf("condition %q not met", condition)
+			}
+			return nil
+		}
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ms, ok := macaroonhttp.FromContext(req.Context())
+		if !ok {
+			http.Error(w, "no macaroons in context", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "ok %s %d", ms[0].Id(), len(ms))
+	})
+	ts.srv = httptest.NewServer(macaroonhttp.NewHandler(h, p))
+	return ts
+}
+
+// newMacaroon returns a new macaroon for the server
+// with the given first party caveats.
+func (ts *testServer) newMacaroon(c *gc.C, conditions ...string) *macaroon.Macaroon {
+	rootKey, id, err := ts.store.NewKey()
+	c.Assert(err, gc.IsNil)
+	m, err := macaroon.New(rootKey, id, "server", macaroon.LatestVersion)
+	c.Assert(err, gc.IsNil)
+	for _, cond := range conditions {
+		err := m.AddFirstPartyCaveat(cond)
+		c.Assert(err, gc.IsNil)
+	}
+	return m
+}
+
+func get(c *gc.C, req *http.Request) (int, string) {
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	return resp.StatusCode, string(data)
+}
+
+func text(c *gc.C, ms macaroon.Slice) string {
+	data, err := ms.MarshalText()
+	c.Assert(err, gc.IsNil)
+	return string(data)
+}
+
+func (*handlerSuite) TestAuthorizationHeader(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{})
+	defer ts.srv.Close()
+	m := ts.newMacaroon(c, "path /foo")
+
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 1")
+
+	// The caveat restricts the macaroon to /foo.
+	req, err = http.NewRequest("GET", ts.srv.URL+"/bar", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	status, body = get(c, req)
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.Equals, `condition "path /foo" not met`+"\n")
+}
+
+func (*handlerSuite) TestCookies(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{})
+	defer ts.srv.Close()
+	m0 := ts.newMacaroon(c, "path /bar")
+	m1 := ts.newMacaroon(c, "path /foo")
+
+	// The first cookie does not verify, so the second is used.
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	req.AddCookie(&http.Cookie{
+		Name:  "macaroon-0",
+		Value: text(c, macaroon.Slice{m0}),
+	})
+	req.AddCookie(&http.Cookie{
+		Name:  "other",
+		Value: "ignored",
+	})
+	req.AddCookie(&http.Cookie{
+		Name:  "macaroon-1",
+		Value: text(c, macaroon.Slice{m1}),
+	})
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 1 1")
+}
+
+func (*handlerSuite) TestDischarges(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{})
+	defer ts.srv.Close()
+	d := &macaroontest.Discharger{
+		Location: "discharger",
+		Check: func(cond string) error {
+			if cond != "is-ok" {
+				return fmt.Errorf("not ok")
+			}
+			return nil
+		},
+		Rand: macaroontest.NewFixedRand([]byte("discharger")),
+	}
+	m := ts.newMacaroon(c)
+	err := d.AddCaveat(m, "is-ok")
+	c.Assert(err, gc.IsNil)
+
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.Equals, `cannot find discharge macaroon for caveat 646973636861726765722d30`+"\n")
+
+	ms, err := d.DischargeAll(m)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, ms)
+	c.Assert(err, gc.IsNil)
+	status, body = get(c, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 2")
+}
+
+var handlerErrorTests = []struct {
+	about         string
+	authorization string
+	cookie        *http.Cookie
+	expectStatus  int
+	expectBody    string
+}{{
+	about:        "no macaroons",
+	expectStatus: http.StatusUnauthorized,
+	expectBody:   "no macaroons found in request\n",
+}, {
+	about:         "other authorization scheme",
+	authorization: "Basic dXNlcjpwYXNz",
+	expectStatus:  http.StatusUnauthorized,
+	expectBody:    "no macaroons found in request\n",
+}, {
+	about:         "invalid authorization header",
+	authorization: "Macaroon !!!",
+	expectStatus:  http.StatusBadRequest,
+	expectBody:    "invalid Authorization header: .*\n",
+}, {
+	about:        "invalid cookie",
+	cookie:       &http.Cookie{Name: "macaroon-x", Value: "AAAA"},
+	expectStatus: http.StatusBadRequest,
+	expectBody:   `invalid cookie "macaroon-x": .*\n`,
+}, {
+	about:         "invalid authorization header and cookie",
+	authorization: "Macaroon !!!",
+	cookie:        &http.Cookie{Name: "macaroon-x", Value: "AAAA"},
+	expectStatus:  http.StatusBadRequest,
+	expectBody:    "invalid Authorization header: .*\n",
+}, {
+	about:         "empty slice",
+	authorization: "Macaroon ",
+	expectStatus:  http.StatusUnauthorized,
+	expectBody:    "empty macaroon slice\n",
+}}
+
+func (*handlerSuite) TestHandlerErrors(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{})
+	defer ts.srv.Close()
+	for i, test := range handlerErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+		c.Assert(err, gc.IsNil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		if test.cookie != nil {
+			req.AddCookie(test.cookie)
+		}
+		status, body := get(c, req)
+		c.Check(status, gc.Equals, test.expectStatus)
+		c.Check(body, gc.Matches, test.expectBody)
+	}
+}
+
+func (*handlerSuite) TestUnknownRootKey(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{})
+	defer ts.srv.Close()
+	m, err := macaroon.New([]byte("key"), []byte("unknown"), "server", macaroon.LatestVersion)
+	c.Assert(err, gc.IsNil)
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.Equals, `cannot find root key for macaroon "unknown"`+"\n")
+}
+
+func (*handlerSuite) TestWrappedUnknownRootKey(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{
+		RootKey: func(ctx context.Context, id []byte) ([]byte, error) {
+			return nil, fmt.Errorf("cannot get key %q: %w", id, macaroonhttp.ErrUnknownRootKey)
+		},
+	})
+	defer ts.srv.Close()
+	m := ts.newMacaroon(c)
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.Equals, `cannot find root key for macaroon "0"`+"\n")
+}
+
+func (*handlerSuite) TestRootKeyError(c *gc.C) {
+	var logBuf bytes.Buffer
+	ts := newTestServer(c, macaroonhttp.Params{
+		RootKey: func(ctx context.Context, id []byte) ([]byte, error) {
+			return nil, fmt.Errorf("store unavailable at db.internal:5432")
+		},
+		ErrorLog: log.New(&logBuf, "", 0),
+	})
+	defer ts.srv.Close()
+	m := ts.newMacaroon(c)
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusInternalServerError)
+	// The details are logged but not sent to the client.
+	c.Assert(body, gc.Equals, "internal server error\n")
+	c.Assert(logBuf.String(), gc.Equals, "macaroonhttp: cannot get root key: store unavailable at db.internal:5432\n")
+}
+
+func (*handlerSuite) TestDischargeRequiredResponse(c *gc.C) {
+	var ts *testServer
+	ts = newTestServer(c, macaroonhttp.Params{
+		NewMacaroon: func(req *http.Request) (*macaroon.Macaroon, error) {
+			return ts.newMacaroon(c, "path "+req.URL.Path), nil
+		},
+	})
+	defer ts.srv.Close()
+	resp, err := http.Get(ts.srv.URL + "/foo")
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+	c.Assert(resp.Header.Get("WWW-Authenticate"), gc.Equals, "Macaroon")
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json")
+	var dresp macaroonhttp.DischargeRequiredResponse
+	err = json.NewDecoder(resp.Body).Decode(&dresp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(dresp.Message, gc.Equals, "no macaroons found in request")
+	c.Assert(dresp.Macaroon, gc.NotNil)
+
+	// The returned macaroon can be used to retry the request.
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{dresp.Macaroon})
+	c.Assert(err, gc.IsNil)
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 1")
+}
+
+func (*handlerSuite) TestUndecodableCookieIgnored(c *gc.C) {
+	ts := newTestServer(c, macaroonhttp.Params{})
+	defer ts.srv.Close()
+	m := ts.newMacaroon(c, "path /foo")
+
+	req, err := http.NewRequest("GET", ts.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+	c.Assert(err, gc.IsNil)
+	req.AddCookie(&http.Cookie{
+		Name:  "macaroon-stale",
+		Value: "AAAA",
+	})
+	status, body := get(c, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 1")
+}
+
+func (*handlerSuite) TestNilRootKeyPanics(c *gc.C) {
+	c.Assert(func() {
+		macaroonhttp.NewHandler(http.NotFoundHandler(), macaroonhttp.Params{})
+	}, gc.PanicMatches, `macaroonhttp: NewHandler called with nil Params.RootKey`)
+}
+
+func (*handlerSuite) TestNilCheckRejectsCaveats(c *gc.C) {
+	rootKey := []byte("root key")
+	h := macaroonhttp.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "ok")
+	}), macaroonhttp.Params{
+		RootKey: func(ctx context.Context, id []byte) ([]byte, error) {
+			return rootKey, nil
+		},
+	})
+	serve := func(m *macaroon.Macaroon) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://example.com/", nil)
+		c.Assert(err, gc.IsNil)
+		err = macaroonhttp.SetAuthorization(req, macaroon.Slice{m})
+		c.Assert(err, gc.IsNil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	m := mustNewMacaroon(c, "m")
+	rec := serve(m)
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, "ok")
+
+	err := m.AddFirstPartyCaveat("something")
+	c.Assert(err, gc.IsNil)
+	rec = serve(m)
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(rec.Body.String(), gc.Equals, `caveat "something" not satisfied: no caveat checker`+"\n")
+}
+
+func (*handlerSuite) TestRequestMacaroons(c *gc.C) {
+	m0 := mustNewMacaroon(c, "m0")
+	m1 := mustNewMacaroon(c, "m1")
+	req, err := http.NewRequest("GET", "http://example.com/", nil)
+	c.Assert(err, gc.IsNil)
+	req.AddCookie(&http.Cookie{
+		Name:  "x-0",
+		Value: text(c, macaroon.Slice{m1}),
+	})
+	req.Header.Set("Authorization", "macaroon "+text(c, macaroon.Slice{m0, m1}))
+	slices, err := macaroonhttp.RequestMacaroons(req, "x-")
+	c.Assert(err, gc.IsNil)
+	c.Assert(slices, gc.HasLen, 2)
+	c.Assert(slices[0], gc.HasLen, 2)
+	c.Assert(slices[0][0].Equal(m0), jc.IsTrue)
+	c.Assert(slices[0][1].Equal(m1), jc.IsTrue)
+	c.Assert(slices[1], gc.HasLen, 1)
+	c.Assert(slices[1][0].Equal(m1), jc.IsTrue)
+}
+
+func (*handlerSuite) TestFromContext(c *gc.C) {
+	_, ok := macaroonhttp.FromContext(context.Background())
+	c.Assert(ok, jc.IsFalse)
+	m := mustNewMacaroon(c, "m")
+	ms, ok := macaroonhttp.FromContext(macaroonhttp.NewContext(context.Background(), macaroon.Slice{m}))
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(ms, jc.DeepEquals, macaroon.Slice{m})
+}
+
+func mustNewMacaroon(c *gc.C, id string) *macaroon.Macaroon {
+	m, err := macaroon.New([]byte("root key"), []byte(id), "loc", macaroon.LatestVersion)
+	c.Assert(err, gc.IsNil)
+	return m
+}
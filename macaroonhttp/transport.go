@@ -0,0 +1,212 @@
+package macaroonhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+// maxDischargeRequiredSize holds the maximum size of a
+// discharge-required response body that Transport will read.
+const maxDischargeRequiredSize = 1024 * 1024
+
+// maxDischarges holds the maximum number of discharges that
+// Transport will acquire for a single macaroon, including
+// discharges for third party caveats in other discharges.
+// It stops a third party that keeps adding third party
+// caveats to its discharges from making a request run forever.
+const maxDischarges = 100
+
+// Transport is an http.RoundTripper that sends macaroons with
+// each request in the Authorization header. When a server
+// responds with a DischargeRequiredResponse, it acquires
+// discharges for the third party caveats in the returned
+// macaroon, binds them, stores the result for use in
+// subsequent requests and retries the request once.
+//
+// Macaroons are stored separately for each origin (URL scheme
+// and host) and are only sent to the origin they are stored
+// for, so one server cannot obtain or replace the macaroons
+// used for another, including by redirecting the client.
+//
+// A Transport is safe to use concurrently.
+type Transport struct {
+	// Base holds the transport used to make requests.
+	// If it is nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Discharge acquires a discharge macaroon for the given
+	// third party caveat. The returned macaroon should not
+	// be bound; Transport binds it to the macaroon being
+	// discharged. If Discharge is nil, discharge-required
+	// responses are only handled for macaroons without
+	// third party caveats.
+	Discharge func(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error)
+
+	mu sync.Mutex
+	// macaroons holds the stored macaroons,
+	// keyed by origin.
+	macaroons map[string]macaroon.Slice
+}
+
+// SetMacaroons sets the macaroons sent with requests to
+// the origin of u. If ms is empty, no macaroons will be sent.
+func (t *Transport) SetMacaroons(u *url.URL, ms macaroon.Slice) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(ms) == 0 {
+		delete(t.macaroons, origin(u))
+		return
+	}
+	if t.macaroons == nil {
+		t.macaroons = make(map[string]macaroon.Slice)
+	}
+	t.macaroons[origin(u)] = ms
+}
+
+// Macaroons returns the macaroons sent with
+// requests to the origin of u.
+func (t *Transport) Macaroons(u *url.URL) macaroon.Slice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.macaroons[origin(u)]
+}
+
+// origin returns the key used to store
+// macaroons for requests to u.
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.send(req, t.Macaroons(req.URL))
+	if err != nil {
+		return nil, err
+	}
+	m, err := dischargeRequiredMacaroon(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if m == nil {
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body has been consumed and we can't
+		// get it back, so we can't retry.
+		return resp, nil
+	}
+	resp.Body.Close()
+	ms, err := t.dischargeAll(req.Context(), m)
+	if err != nil {
+		return nil, err
+	}
+	// Store the macaroons only for the origin
+	// that asked for them to be discharged.
+	t.SetMacaroons(req.URL, ms)
+	req1 := req
+	if req.GetBody != nil {
+		req1 = req.Clone(req.Context())
+		req1.Body, err = req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get request body to retry: %v", err)
+		}
+	}
+	return t.send(req1, ms)
+}
+
+// send sends req with the given macaroons. It does not
+// modify req.
+func (t *Transport) send(req *http.Request, ms macaroon.Slice) (*http.Response, error) {
+	if len(ms) > 0 {
+		req = req.Clone(req.Context())
+		if err := SetAuthorization(req, ms); err != nil {
+			return nil, fmt.Errorf("cannot set macaroons in request: %v", err)
+		}
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// dischargeAll returns a slice holding m followed by discharges
+// for all its third party caveats, including those found in
+// the discharges themselves, bound to m. It returns an error
+// if more than maxDischarges discharges are needed.
+func (t *Transport) dischargeAll(ctx context.Context, m *macaroon.Macaroon) (macaroon.Slice, error) {
+	ms := macaroon.Slice{m}
+	sig := m.Signature()
+	for i := 0; i < len(ms); i++ {
+		for _, cav := range ms[i].Caveats() {
+			if len(cav.VerificationId) == 0 {
+				continue
+			}
+			if t.Discharge == nil {
+				return nil, fmt.Errorf("cannot discharge caveat %q: no discharger", cav.Id)
+			}
+			if len(ms) > maxDischarges {
+				return nil, fmt.Errorf("cannot discharge caveat %q: too many discharges (limit %d)", cav.Id, maxDischarges)
+			}
+			dm, err := t.Discharge(ctx, cav)
+			if err != nil {
+				return nil, fmt.Errorf("cannot acquire discharge for caveat %q: %v", cav.Id, err)
+			}
+			if dm == nil {
+				return nil, fmt.Errorf("cannot acquire discharge for caveat %q: no macaroon returned", cav.Id)
+			}
+			dm = dm.Clone()
+			dm.Bind(sig)
+			ms = append(ms, dm)
+		}
+	}
+	return ms, nil
+}
+
+// dischargeRequiredMacaroon returns the macaroon in resp if
+// it holds a DischargeRequiredResponse, or nil otherwise.
+// If the body is read, it is replaced so that it
+// can be read again from the start.
+func dischargeRequiredMacaroon(resp *http.Response) (*macaroon.Macaroon, error) {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, nil
+	}
+	if !strings.EqualFold(resp.Header.Get("WWW-Authenticate"), authScheme) {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return nil, nil
+	}
+	body := resp.Body
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxDischargeRequiredSize))
+	resp.Body = readCloser{
+		Reader: io.MultiReader(bytes.NewReader(data), body),
+		Closer: body,
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read discharge-required response: %v", err)
+	}
+	var dresp DischargeRequiredResponse
+	if err := json.Unmarshal(data, &dresp); err != nil {
+		return nil, nil
+	}
+	return dresp.Macaroon, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,354 @@
+package macaroonhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+	"gopkg.in/macaroon.v2-unstable/macaroonhttp"
+	"gopkg.in/macaroon.v2-unstable/macaroontest"
+)
+
+type transportSuite struct{}
+
+var _ = gc.Suite(&transportSuite{})
+
+// dischargeServer holds a server that responds to unauthorized
+// requests with macaroons that must be discharged by d.
+type dischargeServer struct {
+	*testServer
+	d *macaroontest.Discharger
+
+	// requests holds the number of requests that
+	// have reached the server.
+	requests int32
+}
+
+func newDischargeServer(c *gc.C) *dischargeServer {
+	ds := &dischargeServer{
+		d: &macaroontest.Discharger{
+			Location: "discharger",
+			Check: func(cond string) error {
+				if cond != "is-ok" {
+					return fmt.Errorf("not ok")
+				}
+				return nil
+			},
+			Rand: macaroontest.NewFixedRand([]byte("discharger")),
+		},
+	}
+	ds.testServer = newTestServer(c, macaroonhttp.Params{
+		NewMacaroon: func(req *http.Request) (*macaroon.Macaroon, error) {
+			m := ds.newMacaroon(c)
+			if err := ds.d.AddCaveat(m, req.Header.Get("Condition")); err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+	})
+	h := ds.srv.Config.Handler
+	ds.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&ds.requests, 1)
+		h.ServeHTTP(w, req)
+	})
+	return ds
+}
+
+// discharge discharges caveats with ds.d.
+func (ds *dischargeServer) discharge(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+	return ds.d.Discharge(cav.Id)
+}
+
+func do(c *gc.C, client *http.Client, req *http.Request) (int, string) {
+	resp, err := client.Do(req)
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	return resp.StatusCode, string(data)
+}
+
+func (*transportSuite) TestDischargeAndRetry(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	var discharges int32
+	t := &macaroonhttp.Transport{
+		Discharge: func(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+			atomic.AddInt32(&discharges, 1)
+			return ds.discharge(ctx, cav)
+		},
+	}
+	client := &http.Client{Transport: t}
+
+	req, err := http.NewRequest("GET", ds.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Condition", "is-ok")
+	status, body := do(c, client, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 2")
+	c.Assert(ds.requests, gc.Equals, int32(2))
+	c.Assert(discharges, gc.Equals, int32(1))
+	c.Assert(req.Header.Get("Authorization"), gc.Equals, "")
+
+	ms := t.Macaroons(req.URL)
+	c.Assert(ms, gc.HasLen, 2)
+	c.Assert(string(ms[0].Id()), gc.Equals, "0")
+
+	// The stored macaroons are used for subsequent
+	// requests without discharging again.
+	status, body = do(c, client, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 2")
+	c.Assert(ds.requests, gc.Equals, int32(3))
+	c.Assert(discharges, gc.Equals, int32(1))
+}
+
+func (*transportSuite) TestRetryWithBody(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	var bodies []string
+	h := ds.srv.Config.Handler
+	ds.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := ioutil.ReadAll(req.Body)
+		c.Check(err, gc.IsNil)
+		bodies = append(bodies, string(data))
+		h.ServeHTTP(w, req)
+	})
+	client := &http.Client{
+		Transport: &macaroonhttp.Transport{
+			Discharge: ds.discharge,
+		},
+	}
+	req, err := http.NewRequest("POST", ds.srv.URL+"/foo", strings.NewReader("hello"))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Condition", "is-ok")
+	status, body := do(c, client, req)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 2")
+	c.Assert(bodies, jc.DeepEquals, []string{"hello", "hello"})
+}
+
+func (*transportSuite) TestRetriesOnlyOnce(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	client := &http.Client{
+		Transport: &macaroonhttp.Transport{
+			// Return a discharge that doesn't match the caveat
+			// so that the retried request fails too.
+			Discharge: func(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+				return macaroon.New([]byte("wrong key"), cav.Id, "", macaroon.LatestVersion)
+			},
+		},
+	}
+	req, err := http.NewRequest("GET", ds.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	status, body := do(c, client, req)
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.Matches, `\{"message":"signature mismatch after caveat verification","macaroon":.*\}`)
+	c.Assert(ds.requests, gc.Equals, int32(2))
+}
+
+func (*transportSuite) TestDischargeError(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	client := &http.Client{
+		Transport: &macaroonhttp.Transport{
+			Discharge: ds.discharge,
+		},
+	}
+	req, err := http.NewRequest("GET", ds.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Condition", "not-ok")
+	_, err = client.Do(req)
+	c.Assert(err, gc.ErrorMatches, `Get ".*/foo": cannot acquire discharge for caveat "discharger-0": cannot discharge caveat "discharger-0": not ok`)
+	c.Assert(ds.requests, gc.Equals, int32(1))
+}
+
+func (*transportSuite) TestTooManyDischarges(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	var discharges int32
+	client := &http.Client{
+		Transport: &macaroonhttp.Transport{
+			// Every discharge needs another discharge.
+			Discharge: func(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+				atomic.AddInt32(&discharges, 1)
+				dm, err := ds.discharge(ctx, cav)
+				if err != nil {
+					return nil, err
+				}
+				if err := ds.d.AddCaveat(dm, "is-ok"); err != nil {
+					return nil, err
+				}
+				return dm, nil
+			},
+		},
+	}
+	req, err := http.NewRequest("GET", ds.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Condition", "is-ok")
+	_, err = client.Do(req)
+	c.Assert(err, gc.ErrorMatches, `Get ".*/foo": cannot discharge caveat "discharger-100": too many discharges \(limit 100\)`)
+	c.Assert(discharges, gc.Equals, int32(100))
+	c.Assert(ds.requests, gc.Equals, int32(1))
+}
+
+func (*transportSuite) TestNilDischarge(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	client := &http.Client{
+		Transport: &macaroonhttp.Transport{
+			Discharge: func(ctx context.Context, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+				return nil, nil
+			},
+		},
+	}
+	req, err := http.NewRequest("GET", ds.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	_, err = client.Do(req)
+	c.Assert(err, gc.ErrorMatches, `Get ".*/foo": cannot acquire discharge for caveat "discharger-0": no macaroon returned`)
+}
+
+func (*transportSuite) TestNoDischarger(c *gc.C) {
+	ds := newDischargeServer(c)
+	defer ds.srv.Close()
+	client := &http.Client{
+		Transport: &macaroonhttp.Transport{},
+	}
+	req, err := http.NewRequest("GET", ds.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	_, err = client.Do(req)
+	c.Assert(err, gc.ErrorMatches, `Get ".*/foo": cannot discharge caveat "discharger-0": no discharger`)
+}
+
+func (*transportSuite) TestOtherResponsesUnchanged(c *gc.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Macaroon")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, `{"message":"no macaroon here"}`)
+	}))
+	defer srv.Close()
+	t := &macaroonhttp.Transport{}
+	m := mustNewMacaroon(c, "m")
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	c.Assert(err, gc.IsNil)
+	t.SetMacaroons(req.URL, macaroon.Slice{m})
+	status, body := do(c, &http.Client{Transport: t}, req)
+	c.Assert(status, gc.Equals, http.StatusUnauthorized)
+	c.Assert(body, gc.Equals, `{"message":"no macaroon here"}`)
+	c.Assert(t.Macaroons(req.URL), jc.DeepEquals, macaroon.Slice{m})
+}
+
+func (*transportSuite) TestSendsStoredMacaroons(c *gc.C) {
+	var got []macaroon.Slice
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		slices, err := macaroonhttp.RequestMacaroons(req, macaroonhttp.DefaultCookiePrefix)
+		c.Check(err, gc.IsNil)
+		got = append(got, slices...)
+	}))
+	defer srv.Close()
+	t := &macaroonhttp.Transport{}
+	client := &http.Client{Transport: t}
+	resp, err := client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(got, gc.HasLen, 0)
+
+	m := mustNewMacaroon(c, "m")
+	u, err := url.Parse(srv.URL)
+	c.Assert(err, gc.IsNil)
+	t.SetMacaroons(u, macaroon.Slice{m})
+	resp, err = client.Get(srv.URL)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(got, gc.HasLen, 1)
+	c.Assert(got[0], gc.HasLen, 1)
+	c.Assert(got[0][0].Equal(m), jc.IsTrue)
+}
+
+func (*transportSuite) TestMacaroonsStoredPerOrigin(c *gc.C) {
+	// Server a requires discharged macaroons and
+	// redirects /redirect to server b.
+	a := newDischargeServer(c)
+	defer a.srv.Close()
+
+	// Server b records any macaroons it sees and
+	// responds with its own discharge-required
+	// response until it is sent its macaroon.
+	var bSeen []macaroon.Slice
+	bm := mustNewMacaroon(c, "b")
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		slices, err := macaroonhttp.RequestMacaroons(req, macaroonhttp.DefaultCookiePrefix)
+		c.Check(err, gc.IsNil)
+		bSeen = append(bSeen, slices...)
+		if len(slices) > 0 {
+			fmt.Fprintf(w, "b ok")
+			return
+		}
+		data, err := json.Marshal(&macaroonhttp.DischargeRequiredResponse{
+			Macaroon: bm,
+		})
+		c.Check(err, gc.IsNil)
+		w.Header().Set("WWW-Authenticate", "Macaroon")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(data)
+	}))
+	defer b.Close()
+	h := a.srv.Config.Handler
+	a.srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, b.URL+"/target", http.StatusFound)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+
+	t := &macaroonhttp.Transport{
+		Discharge: a.discharge,
+	}
+	client := &http.Client{Transport: t}
+
+	// Acquire macaroons for server a.
+	reqA, err := http.NewRequest("GET", a.srv.URL+"/foo", nil)
+	c.Assert(err, gc.IsNil)
+	reqA.Header.Set("Condition", "is-ok")
+	status, body := do(c, client, reqA)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 2")
+	aMacaroons := t.Macaroons(reqA.URL)
+	c.Assert(aMacaroons, gc.HasLen, 2)
+
+	// Server a's macaroons are not sent to server b when
+	// server a redirects there, and server b's
+	// discharge-required response does not replace them.
+	reqRedirect, err := http.NewRequest("GET", a.srv.URL+"/redirect", nil)
+	c.Assert(err, gc.IsNil)
+	status, body = do(c, client, reqRedirect)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "b ok")
+	c.Assert(bSeen, gc.HasLen, 1)
+	c.Assert(bSeen[0], gc.HasLen, 1)
+	c.Assert(bSeen[0][0].Equal(bm), jc.IsTrue)
+	c.Assert(t.Macaroons(reqA.URL), jc.DeepEquals, aMacaroons)
+
+	bURL, err := url.Parse(b.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(t.Macaroons(bURL), gc.HasLen, 1)
+
+	// Server a still accepts its macaroons.
+	status, body = do(c, client, reqA)
+	c.Assert(status, gc.Equals, http.StatusOK)
+	c.Assert(body, gc.Equals, "ok 0 2")
+}
@@ -0,0 +1,111 @@
+package macaroontest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+// Discharger is an in-process fake third party that can
+// add third party caveats to macaroons and discharge them.
+// It is safe to use concurrently.
+type Discharger struct {
+	// Location holds the location of the discharger, used as the
+	// location of the third party caveats it adds and of the
+	// discharge macaroons it creates.
+	Location string
+
+	// Check is called by Discharge to check that a caveat's
+	// condition is met. If it returns an error, the caveat
+	// is not discharged. If Check is nil, all conditions
+	// are considered to be met.
+	Check func(condition string) error
+
+	// Rand is used to generate the root keys for third party
+	// caveats and the nonces for their verification ids. If
+	// it is nil, crypto/rand is used.
+	Rand io.Reader
+
+	mu      sync.Mutex
+	caveats map[string]dischargerCaveat
+}
+
+type dischargerCaveat struct {
+	rootKey   []byte
+	condition string
+}
+
+// AddCaveat adds a third party caveat with the given
+// condition to m, addressed to the discharger.
+// The caveat id is opaque and only meaningful to the
+// discharger that created it.
+func (d *Discharger) AddCaveat(m *macaroon.Macaroon, condition string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.caveats == nil {
+		d.caveats = make(map[string]dischargerCaveat)
+	}
+	r := d.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	rootKey := make([]byte, 24)
+	if _, err := io.ReadFull(r, rootKey); err != nil {
+		return fmt.Errorf("cannot generate root key: %v", err)
+	}
+	caveatId := []byte(fmt.Sprintf("%s-%d", d.Location, len(d.caveats)))
+	if err := m.AddThirdPartyCaveatWithRand(rootKey, caveatId, d.Location, r); err != nil {
+		return err
+	}
+	d.caveats[string(caveatId)] = dischargerCaveat{
+		rootKey:   rootKey,
+		condition: condition,
+	}
+	return nil
+}
+
+// Discharge returns a discharge macaroon for the third party
+// caveat with the given id, which must have been added by
+// AddCaveat. The returned macaroon is not bound to
+// any primary macaroon.
+func (d *Discharger) Discharge(caveatId []byte) (*macaroon.Macaroon, error) {
+	d.mu.Lock()
+	cav, ok := d.caveats[string(caveatId)]
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown caveat id %q", caveatId)
+	}
+	if d.Check != nil {
+		if err := d.Check(cav.condition); err != nil {
+			return nil, fmt.Errorf("cannot discharge caveat %q: %v", caveatId, err)
+		}
+	}
+	return macaroon.New(cav.rootKey, caveatId, d.Location, macaroon.LatestVersion)
+}
+
+// DischargeAll returns the primary macaroon m along with bound
+// discharges for all of its third party caveats addressed to d,
+// and for any third party caveats within those discharges,
+// suitable for passing to Macaroon.Verify.
+func (d *Discharger) DischargeAll(m *macaroon.Macaroon) (macaroon.Slice, error) {
+	ms := macaroon.Slice{m}
+	for i := 0; i < len(ms); i++ {
+		for _, cav := range ms[i].Caveats() {
+			if len(cav.VerificationId) == 0 || cav.Location != d.Location {
+				continue
+			}
+			dm, err := d.Discharge(cav.Id)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, dm)
+		}
+	}
+	for _, dm := range ms[1:] {
+		dm.Bind(m.Signature())
+	}
+	return ms, nil
+}
@@ -0,0 +1,150 @@
+package macaroontest_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+	"gopkg.in/macaroon.v2-unstable/macaroontest"
+)
+
+func TestPackage(t *testing.T) {
+	gc.TestingT(t)
+}
+
+type suite struct{}
+
+var _ = gc.Suite(&suite{})
+
+func (*suite) TestFixedRand(c *gc.C) {
+	read := func(r io.Reader, n int) []byte {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		c.Assert(err, gc.IsNil)
+		return buf
+	}
+	r0 := macaroontest.NewFixedRand([]byte("seed"))
+	r1 := macaroontest.NewFixedRand([]byte("seed"))
+	r2 := macaroontest.NewFixedRand([]byte("other seed"))
+
+	// The stream is the same regardless of read sizes.
+	b0 := read(r0, 100)
+	b1 := append(read(r1, 7), read(r1, 93)...)
+	c.Assert(b1, jc.DeepEquals, b0)
+	c.Assert(read(r2, 100), gc.Not(jc.DeepEquals), b0)
+
+	// Subsequent reads return different data.
+	c.Assert(read(r0, 32), gc.Not(jc.DeepEquals), b0[0:32])
+}
+
+func (*suite) TestRootKeyStore(c *gc.C) {
+	store := macaroontest.NewRootKeyStore(macaroontest.NewFixedRand(nil))
+	rootKey0, id0, err := store.NewKey()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(id0), gc.Equals, "0")
+	rootKey1, id1, err := store.NewKey()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(id1), gc.Equals, "1")
+	c.Assert(rootKey1, gc.Not(jc.DeepEquals), rootKey0)
+
+	k, err := store.Get(id0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(k, jc.DeepEquals, rootKey0)
+
+	store.Set([]byte("x"), []byte("key"))
+	k, err = store.Get([]byte("x"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(k), gc.Equals, "key")
+
+	_, err = store.Get([]byte("unknown"))
+	c.Assert(err, gc.Equals, macaroontest.ErrNotFound)
+}
+
+func (*suite) TestDischarger(c *gc.C) {
+	d := &macaroontest.Discharger{
+		Location: "bob",
+		Check: func(cond string) error {
+			if cond != "is-ok" {
+				return fmt.Errorf("not ok")
+			}
+			return nil
+		},
+	}
+	rootKey := []byte("secret")
+	m, err := macaroon.New(rootKey, []byte("some id"), "", macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	err = m.AddFirstPartyCaveat("first")
+	c.Assert(err, gc.IsNil)
+	err = d.AddCaveat(m, "is-ok")
+	c.Assert(err, gc.IsNil)
+
+	ms, err := d.DischargeAll(m)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms, gc.HasLen, 2)
+	c.Assert(ms[1].Location(), gc.Equals, "bob")
+	err = m.Verify(rootKey, func(string) error { return nil }, ms[1:])
+	c.Assert(err, gc.IsNil)
+
+	err = d.AddCaveat(m, "not-ok")
+	c.Assert(err, gc.IsNil)
+	_, err = d.DischargeAll(m)
+	c.Assert(err, gc.ErrorMatches, `cannot discharge caveat "bob-1": not ok`)
+
+	_, err = d.Discharge([]byte("unknown"))
+	c.Assert(err, gc.ErrorMatches, `unknown caveat id "unknown"`)
+}
+
+func (*suite) TestMakeMacaroons(c *gc.C) {
+	specs := []macaroontest.MacaroonSpec{{
+		RootKey: "root-key",
+		Id:      "root-id",
+		Caveats: []macaroontest.CaveatSpec{{
+			Condition: "wonderful",
+		}, {
+			Condition: "bob-is-great",
+			Location:  "bob",
+			RootKey:   "bob-caveat-root-key",
+		}},
+	}, {
+		Location: "bob",
+		RootKey:  "bob-caveat-root-key",
+		Id:       "bob-is-great",
+		Caveats: []macaroontest.CaveatSpec{{
+			Condition: "splendid",
+		}},
+	}}
+	rootKey, primary, discharges := macaroontest.MakeMacaroons(specs)
+	c.Assert(string(rootKey), gc.Equals, "root-key")
+	c.Assert(discharges, gc.HasLen, 1)
+	var checked []string
+	err := primary.Verify(rootKey, func(cond string) error {
+		checked = append(checked, cond)
+		return nil
+	}, discharges)
+	c.Assert(err, gc.IsNil)
+	c.Assert(checked, jc.DeepEquals, []string{"wonderful", "splendid"})
+
+	// The macaroons are reproducible.
+	_, primary1, discharges1 := macaroontest.MakeMacaroons(specs)
+	c.Assert(primary1, jc.DeepEquals, primary)
+	c.Assert(discharges1, jc.DeepEquals, discharges)
+}
+
+func (*suite) TestMakeMacaroonError(c *gc.C) {
+	_, err := macaroontest.MakeMacaroon(macaroontest.MacaroonSpec{
+		Id:      "some id",
+		Version: macaroon.V1,
+		Caveats: []macaroontest.CaveatSpec{{
+			Condition: "\xff",
+		}},
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot add caveat 0 to macaroon "some id": first party caveat condition is not a valid utf-8 string`)
+
+	c.Assert(func() {
+		macaroontest.MakeMacaroons(nil)
+	}, gc.PanicMatches, `no macaroons specified`)
+}
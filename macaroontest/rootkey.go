@@ -0,0 +1,71 @@
+package macaroontest
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrNotFound is returned by RootKeyStore.Get when
+// there is no root key with the requested id.
+var ErrNotFound = errors.New("root key not found")
+
+// RootKeyStore is an in-memory store of macaroon root keys,
+// indexed by id. It is safe to use concurrently.
+type RootKeyStore struct {
+	mu    sync.Mutex
+	rand  io.Reader
+	keys  map[string][]byte
+	count int
+}
+
+// NewRootKeyStore returns a new empty root key store. New root
+// keys are generated by reading from r, or from crypto/rand
+// if r is nil.
+func NewRootKeyStore(r io.Reader) *RootKeyStore {
+	if r == nil {
+		r = rand.Reader
+	}
+	return &RootKeyStore{
+		rand: r,
+		keys: make(map[string][]byte),
+	}
+}
+
+// NewKey generates a new root key, stores it and
+// returns it along with its id. The ids are
+// allocated sequentially, starting at "0".
+func (s *RootKeyStore) NewKey() (rootKey, id []byte, err error) {
+	rootKey = make([]byte, 24)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := io.ReadFull(s.rand, rootKey); err != nil {
+		return nil, nil, fmt.Errorf("cannot generate root key: %v", err)
+	}
+	id = []byte(fmt.Sprint(s.count))
+	s.count++
+	s.keys[string(id)] = rootKey
+	return append([]byte(nil), rootKey...), id, nil
+}
+
+// Set stores the given root key with the given id,
+// replacing any existing key with that id.
+func (s *RootKeyStore) Set(id, rootKey []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[string(id)] = append([]byte(nil), rootKey...)
+}
+
+// Get returns the root key with the given id. It returns
+// ErrNotFound if there is no such key.
+func (s *RootKeyStore) Get(id []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rootKey, ok := s.keys[string(id)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), rootKey...), nil
+}
@@ -0,0 +1,113 @@
+package macaroontest
+
+import (
+	"fmt"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+// MacaroonSpec holds a compact specification of a macaroon,
+// for use with MakeMacaroons.
+type MacaroonSpec struct {
+	// RootKey holds the root key of the macaroon.
+	RootKey string
+
+	// Id holds the identifier of the macaroon. For a discharge
+	// macaroon, this should be the condition of the third party
+	// caveat that it discharges.
+	Id string
+
+	// Location holds the location of the macaroon.
+	Location string
+
+	// Caveats holds the macaroon's caveats.
+	Caveats []CaveatSpec
+
+	// Version holds the version of the macaroon.
+	// If it is zero, macaroon.LatestVersion is used.
+	Version macaroon.Version
+}
+
+// CaveatSpec holds a compact specification of a caveat.
+type CaveatSpec struct {
+	// Condition holds the caveat's condition. For a third
+	// party caveat, it is used as the caveat id.
+	Condition string
+
+	// Location holds the location of a third party caveat.
+	// If it is empty, the caveat is a first party caveat.
+	Location string
+
+	// RootKey holds the root key of a third party caveat.
+	RootKey string
+}
+
+// MakeMacaroon returns the macaroon described by spec.
+// Macaroons made from the same specification are identical,
+// as third party caveats are created with a fixed random
+// source seeded from the macaroon's root key and id.
+func MakeMacaroon(spec MacaroonSpec) (*macaroon.Macaroon, error) {
+	vers := spec.Version
+	if vers == 0 {
+		vers = macaroon.LatestVersion
+	}
+	m, err := macaroon.New([]byte(spec.RootKey), []byte(spec.Id), spec.Location, vers)
+	if err != nil {
+		return nil, err
+	}
+	r := NewFixedRand([]byte(spec.RootKey + "\x00" + spec.Id))
+	for i, cav := range spec.Caveats {
+		if cav.Location != "" {
+			err = m.AddThirdPartyCaveatWithRand([]byte(cav.RootKey), []byte(cav.Condition), cav.Location, r)
+		} else {
+			err = m.AddFirstPartyCaveat(cav.Condition)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot add caveat %d to macaroon %q: %v", i, spec.Id, err)
+		}
+	}
+	return m, nil
+}
+
+// MakeMacaroons returns the macaroons described by specs.
+// The first macaroon is the primary macaroon; the rest are
+// discharge macaroons, which are bound to the primary. It also
+// returns the primary macaroon's root key. The result is
+// suitable for passing to Macaroon.Verify. For example:
+//
+//	rootKey, primary, discharges := macaroontest.MakeMacaroons([]macaroontest.MacaroonSpec{{
+//		RootKey: "root-key",
+//		Id:      "root-id",
+//		Caveats: []macaroontest.CaveatSpec{{
+//			Condition: "wonderful",
+//		}, {
+//			Condition: "bob-is-great",
+//			Location:  "bob",
+//			RootKey:   "bob-caveat-root-key",
+//		}},
+//	}, {
+//		Location: "bob",
+//		RootKey:  "bob-caveat-root-key",
+//		Id:       "bob-is-great",
+//	}})
+//
+// MakeMacaroons panics if specs is empty or any of the
+// macaroons cannot be made.
+func MakeMacaroons(specs []MacaroonSpec) (rootKey []byte, primary *macaroon.Macaroon, discharges []*macaroon.Macaroon) {
+	if len(specs) == 0 {
+		panic("no macaroons specified")
+	}
+	ms := make([]*macaroon.Macaroon, len(specs))
+	for i, spec := range specs {
+		m, err := MakeMacaroon(spec)
+		if err != nil {
+			panic(err)
+		}
+		ms[i] = m
+	}
+	primary, discharges = ms[0], ms[1:]
+	for _, m := range discharges {
+		m.Bind(primary.Signature())
+	}
+	return []byte(specs[0].RootKey), primary, discharges
+}
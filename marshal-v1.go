@@ -16,6 +16,14 @@ type macaroonJSONV1 struct {
 	Signature  string         `json:"signature"` // hex-encoded
 }
 
+// isZero reports whether none of the fields in mjson are set.
+func (mjson *macaroonJSONV1) isZero() bool {
+	return mjson.Caveats == nil &&
+		mjson.Location == "" &&
+		mjson.Identifier == "" &&
+		mjson.Signature == ""
+}
+
 // caveatJSONV1 defines the V1 JSON format for caveats within a macaroon.
 type caveatJSONV1 struct {
 	CID      string `json:"cid"`
@@ -71,7 +79,9 @@ func (m *Macaroon) initJSONV1(mjson *macaroonJSONV1) error {
 		}
 		m.appendCaveat([]byte(cav.CID), vid, cav.Location)
 	}
-	return nil
+	// Check that the macaroon can be marshaled
+	// in the V1 binary format too.
+	return m.checkV1()
 }
 
 // The original (v1) binary format of a macaroon is as follows.
@@ -89,18 +99,27 @@ func (m *Macaroon) initJSONV1(mjson *macaroonJSONV1) error {
 // parseBinaryV1 parses the given data in V1 format into the macaroon. The macaroon's
 // internal data structures will retain references to the data. It
 // returns the data after the end of the macaroon.
-func (m *Macaroon) parseBinaryV1(data []byte) ([]byte, error) {
+//
+// The caveat and field length limits in o are
+// checked as each packet is parsed.
+func (m *Macaroon) parseBinaryV1(data []byte, o *UnmarshalOptions) ([]byte, error) {
 	var err error
 
 	loc, err := expectPacketV1(data, fieldNameLocation)
 	if err != nil {
 		return nil, err
 	}
+	if err := o.checkFieldLen(len(loc.data)); err != nil {
+		return nil, err
+	}
 	data = data[loc.totalLen:]
 	id, err := expectPacketV1(data, fieldNameIdentifier)
 	if err != nil {
 		return nil, err
 	}
+	if err := o.checkFieldLen(len(id.data)); err != nil {
+		return nil, err
+	}
 	data = data[id.totalLen:]
 	m.init(id.data, string(loc.data), V1)
 	var cav Caveat
@@ -110,6 +129,11 @@ func (m *Macaroon) parseBinaryV1(data []byte) ([]byte, error) {
 			return nil, err
 		}
 		data = data[p.totalLen:]
+		if string(p.fieldName) != fieldNameSignature {
+			if err := o.checkFieldLen(len(p.data)); err != nil {
+				return nil, err
+			}
+		}
 		switch field := string(p.fieldName); field {
 		case fieldNameSignature:
 			// At the end of the caveats we find the signature.
@@ -126,6 +150,9 @@ func (m *Macaroon) parseBinaryV1(data []byte) ([]byte, error) {
 				m.caveats = append(m.caveats, cav)
 				cav = Caveat{}
 			}
+			if err := o.checkCaveatCount(len(m.caveats) + 1); err != nil {
+				return nil, err
+			}
 			cav.Id = p.data
 		case fieldNameVerificationId:
 			if cav.VerificationId != nil {
@@ -188,3 +215,55 @@ func (m *Macaroon) appendBinaryV1(data []byte) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// checkV1 checks that m can be represented in the V1 format.
+func (m *Macaroon) checkV1() error {
+	if !utf8.Valid(m.id) {
+		return fmt.Errorf("macaroon id is not valid UTF-8")
+	}
+	if err := checkPacketV1(fieldNameLocation, []byte(m.location)); err != nil {
+		return err
+	}
+	if err := checkPacketV1(fieldNameIdentifier, m.id); err != nil {
+		return err
+	}
+	if len(m.extra) > 0 {
+		return fmt.Errorf("macaroon has fields not supported in %v", V1)
+	}
+	for i, cav := range m.caveats {
+		if !utf8.Valid(cav.Id) {
+			return fmt.Errorf("caveat %d id is not valid UTF-8", i)
+		}
+		if len(cav.Extra) > 0 {
+			return fmt.Errorf("caveat %d has fields not supported in %v", i, V1)
+		}
+		if err := checkCaveatV1(cav.Id, cav.VerificationId, cav.Location); err != nil {
+			return fmt.Errorf("caveat %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// checkCaveatV1 checks that a caveat with the given fields
+// can be represented in the V1 format.
+func checkCaveatV1(caveatId, verificationId []byte, loc string) error {
+	if err := checkPacketV1(fieldNameCaveatId, caveatId); err != nil {
+		return err
+	}
+	if len(verificationId) == 0 {
+		return nil
+	}
+	if err := checkPacketV1(fieldNameVerificationId, verificationId); err != nil {
+		return err
+	}
+	return checkPacketV1(fieldNameCaveatLocation, []byte(loc))
+}
+
+// checkPacketV1 checks that a V1 packet with the given
+// field name and data is not too long to be encoded.
+func checkPacketV1(field string, data []byte) error {
+	if packetV1Size(field, data) > maxPacketV1Len {
+		return fmt.Errorf("%s too long for %v macaroon (%d bytes)", field, V1, len(data))
+	}
+	return nil
+}
@@ -18,34 +18,62 @@ type macaroonJSONV2 struct {
 	Signature     string         `json:"s,omitempty"`
 	SignatureHex  string         `json:"sH,omitempty"`
 	Signature64   string         `json:"s64,omitempty"`
+	Extra         []fieldJSONV2  `json:"x,omitempty"`
+}
+
+// isZero reports whether none of the fields in mjson are set.
+func (mjson *macaroonJSONV2) isZero() bool {
+	return mjson.Caveats == nil &&
+		mjson.Location == "" &&
+		mjson.Identifier == "" &&
+		mjson.IdentifierHex == "" &&
+		mjson.Identifier64 == "" &&
+		mjson.Signature == "" &&
+		mjson.SignatureHex == "" &&
+		mjson.Signature64 == "" &&
+		mjson.Extra == nil
 }
 
 // caveatJSONV2 defines the V2 JSON format for caveats within a macaroon.
 type caveatJSONV2 struct {
-	CID      string `json:"i,omitempty"`
-	CIDHex   string `json:"iH,omitempty"`
-	CID64    string `json:"i64,omitempty"`
-	VID      string `json:"v,omitempty"`
-	VIDHex   string `json:"vH,omitempty"`
-	VID64    string `json:"v64,omitempty"`
-	Location string `json:"l,omitempty"`
+	CID      string        `json:"i,omitempty"`
+	CIDHex   string        `json:"iH,omitempty"`
+	CID64    string        `json:"i64,omitempty"`
+	VID      string        `json:"v,omitempty"`
+	VIDHex   string        `json:"vH,omitempty"`
+	VID64    string        `json:"v64,omitempty"`
+	Location string        `json:"l,omitempty"`
+	Extra    []fieldJSONV2 `json:"x,omitempty"`
+}
+
+// fieldJSONV2 defines the V2 JSON format for fields of
+// a macaroon or caveat not understood by this package.
+type fieldJSONV2 struct {
+	Type    int    `json:"t"`
+	Data    string `json:"d,omitempty"`
+	DataHex string `json:"dH,omitempty"`
+	Data64  string `json:"d64,omitempty"`
 }
 
-func (m *Macaroon) marshalJSONV2() ([]byte, error) {
+// marshalJSONV2 marshals the macaroon to the V2 JSON format,
+// encoding binary fields as specified by e.
+func (m *Macaroon) marshalJSONV2(e *JSONEncoder) ([]byte, error) {
 	mjson := macaroonJSONV2{
 		Location: m.location,
 		Caveats:  make([]caveatJSONV2, len(m.caveats)),
 	}
-	putJSONBinaryField(m.id, &mjson.Identifier, &mjson.IdentifierHex, &mjson.Identifier64)
-	putJSONBinaryField(m.sig[:], &mjson.Signature, &mjson.SignatureHex, &mjson.Signature64)
+	e.putBinaryField(m.id, &mjson.Identifier, &mjson.IdentifierHex, &mjson.Identifier64)
+	e.putBinaryField(m.sig[:], &mjson.Signature, &mjson.SignatureHex, &mjson.Signature64)
 	for i, cav := range m.caveats {
 		cavjson := caveatJSONV2{
 			Location: cav.Location,
 		}
-		putJSONBinaryField(cav.Id, &cavjson.CID, &cavjson.CIDHex, &cavjson.CID64)
-		putJSONBinaryField(cav.VerificationId, &cavjson.VID, &cavjson.VIDHex, &cavjson.VID64)
+		e.putBinaryField(cav.Id, &cavjson.CID, &cavjson.CIDHex, &cavjson.CID64)
+		e.putBinaryField(cav.VerificationId, &cavjson.VID, &cavjson.VIDHex, &cavjson.VID64)
+		cavjson.Extra = e.extraFieldsJSON(cav.Extra)
 		mjson.Caveats[i] = cavjson
 	}
+	mjson.Extra = e.extraFieldsJSON(m.extra)
 	data, err := json.Marshal(mjson)
 	if err != nil {
 		return nil, fmt.Errorf("cannot marshal json data: %v", err)
@@ -79,20 +107,79 @@ func (m *Macaroon) initJSONV2(mjson *macaroonJSONV2) error {
 		if err != nil {
 			return fmt.Errorf("invalid vid in caveat: %v", err)
 		}
+		extra, err := extraFieldsFromJSON(cav.Extra)
+		if err != nil {
+			return fmt.Errorf("invalid caveat: %v", err)
+		}
 		m.appendCaveat(cid, vid, cav.Location)
+		m.caveats[len(m.caveats)-1].Extra = extra
 	}
+	extra, err := extraFieldsFromJSON(mjson.Extra)
+	if err != nil {
+		return err
+	}
+	m.extra = extra
 	return nil
 }
 
-// putJSONBinaryField puts the value of x into one
-// of the appropriate fields depending on its value.
-func putJSONBinaryField(x []byte, s, shex, sb64 *string) {
-	if utf8.Valid(x) {
+// extraFieldsJSON returns the JSON representation
+// of the given extra fields.
+func (e *JSONEncoder) extraFieldsJSON(fields []Field) []fieldJSONV2 {
+	if len(fields) == 0 {
+		return nil
+	}
+	fjson := make([]fieldJSONV2, len(fields))
+	for i, f := range fields {
+		fjson[i].Type = f.Type
+		e.putBinaryField(f.Data, &fjson[i].Data, &fjson[i].DataHex, &fjson[i].Data64)
+	}
+	return fjson
+}
+
+// extraFieldsFromJSON returns the extra fields
+// represented by the given JSON values.
+func extraFieldsFromJSON(fjson []fieldJSONV2) ([]Field, error) {
+	if len(fjson) == 0 {
+		return nil, nil
+	}
+	fields := make([]Field, len(fjson))
+	prevType := 0
+	for i, f := range fjson {
+		if isKnownFieldTypeV2(fieldType(f.Type)) || f.Type <= prevType {
+			return nil, fmt.Errorf("invalid extra field type %d", f.Type)
+		}
+		data, err := jsonBinaryField(f.Data, f.DataHex, f.Data64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extra field data: %v", err)
+		}
+		fields[i] = Field{
+			Type: f.Type,
+			Data: data,
+		}
+		prevType = f.Type
+	}
+	return fields, nil
+}
+
+// putBinaryField puts the value of x into one
+// of the appropriate fields depending on its value
+// and the encoder's options.
+func (e *JSONEncoder) putBinaryField(x []byte, s, shex, sb64 *string) {
+	if len(x) == 0 {
+		return
+	}
+	if !e.ForceBinary && utf8.Valid(x) {
 		*s = string(x)
 		return
 	}
-	// TODO use hex encoding when the field is small?
-	*sb64 = base64.RawURLEncoding.EncodeToString(x)
+	switch e.BinaryEncoding {
+	case JSONHex:
+		*shex = hex.EncodeToString(x)
+	case JSONBase64Std:
+		*sb64 = base64.StdEncoding.EncodeToString(x)
+	default:
+		*sb64 = base64.RawURLEncoding.EncodeToString(x)
+	}
 }
 
 // jsonBinaryField returns the value of a JSON field that may
@@ -137,7 +224,10 @@ func jsonBinaryField(s, shex, sb64 string) ([]byte, error) {
 // parseBinaryV2 parses the given data in V2 format into the macaroon. The macaroon's
 // internal data structures will retain references to the data. It
 // returns the data after the end of the macaroon.
-func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
+//
+// The caveat and field length limits in o are
+// checked as each section is parsed.
+func (m *Macaroon) parseBinaryV2(data []byte, o *UnmarshalOptions) ([]byte, error) {
 	// The version has already been checked, so
 	// skip it.
 	data = data[1:]
@@ -146,16 +236,24 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := o.checkSectionV2(section); err != nil {
+		return nil, err
+	}
 	var loc string
 	if len(section) > 0 && section[0].fieldType == fieldLocation {
 		loc = string(section[0].data)
 		section = section[1:]
 	}
-	if len(section) != 1 || section[0].fieldType != fieldIdentifier {
+	if len(section) == 0 || section[0].fieldType != fieldIdentifier {
 		return nil, fmt.Errorf("invalid macaroon header")
 	}
 	id := section[0].data
+	extra, err := extraFieldsV2(section[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid macaroon header: %v", err)
+	}
 	m.init(id, loc, V2)
+	m.extra = extra
 	for {
 		rest, section, err := parseSectionV2(data)
 		if err != nil {
@@ -165,6 +263,12 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 		if len(section) == 0 {
 			break
 		}
+		if err := o.checkCaveatCount(len(m.caveats) + 1); err != nil {
+			return nil, err
+		}
+		if err := o.checkSectionV2(section); err != nil {
+			return nil, err
+		}
 		var cav Caveat
 		if len(section) > 0 && section[0].fieldType == fieldLocation {
 			cav.Location = string(section[0].data)
@@ -175,21 +279,22 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 		}
 		cav.Id = section[0].data
 		section = section[1:]
-		if len(section) == 0 {
-			// First party caveat.
-			if cav.Location != "" {
-				return nil, fmt.Errorf("location not allowed in first party caveat")
+		for _, p := range section {
+			if p.fieldType == fieldVerificationId {
+				cav.VerificationId = p.data
+				continue
 			}
-			m.caveats = append(m.caveats, cav)
-			continue
-		}
-		if len(section) != 1 {
-			return nil, fmt.Errorf("extra fields found in caveat")
+			if isKnownFieldTypeV2(p.fieldType) {
+				return nil, fmt.Errorf("invalid field found in caveat")
+			}
+			cav.Extra = append(cav.Extra, Field{
+				Type: int(p.fieldType),
+				Data: p.data,
+			})
 		}
-		if section[0].fieldType != fieldVerificationId {
-			return nil, fmt.Errorf("invalid field found in caveat")
+		if cav.VerificationId == nil && cav.Location != "" {
+			return nil, fmt.Errorf("location not allowed in first party caveat")
 		}
-		cav.VerificationId = section[0].data
 		m.caveats = append(m.caveats, cav)
 	}
 	data, sig, err := parsePacketV2(data)
@@ -211,35 +316,38 @@ func (m *Macaroon) parseBinaryV2(data []byte) ([]byte, error) {
 func (m *Macaroon) appendBinaryV2(data []byte) []byte {
 	// Version byte.
 	data = append(data, 2)
+	var packets [3]packetV2
+	section := packets[:0]
 	if len(m.location) > 0 {
-		data = appendPacketV2(data, packetV2{
+		section = append(section, packetV2{
 			fieldType: fieldLocation,
 			data:      []byte(m.location),
 		})
 	}
-	data = appendPacketV2(data, packetV2{
+	section = append(section, packetV2{
 		fieldType: fieldIdentifier,
 		data:      m.id,
 	})
-	data = appendEOSV2(data)
+	data = appendSectionV2(data, section, m.extra)
 	for _, cav := range m.caveats {
+		section := packets[:0]
 		if len(cav.Location) > 0 {
-			data = appendPacketV2(data, packetV2{
+			section = append(section, packetV2{
 				fieldType: fieldLocation,
 				data:      []byte(cav.Location),
 			})
 		}
-		data = appendPacketV2(data, packetV2{
+		section = append(section, packetV2{
 			fieldType: fieldIdentifier,
 			data:      cav.Id,
 		})
 		if len(cav.VerificationId) > 0 {
-			data = appendPacketV2(data, packetV2{
+			section = append(section, packetV2{
 				fieldType: fieldVerificationId,
 				data:      []byte(cav.VerificationId),
 			})
 		}
-		data = appendEOSV2(data)
+		data = appendSectionV2(data, section, cav.Extra)
 	}
 	data = appendEOSV2(data)
 	data = appendPacketV2(data, packetV2{
@@ -248,3 +356,41 @@ func (m *Macaroon) appendBinaryV2(data []byte) []byte {
 	})
 	return data
 }
+
+// appendSectionV2 appends a section holding the given
+// packets and extra fields, followed by an EOS packet. Both
+// packets and extra must be in ascending order of field
+// type; the two are merged so that all the fields in the
+// section are in order.
+func appendSectionV2(data []byte, packets []packetV2, extra []Field) []byte {
+	for _, f := range extra {
+		for len(packets) > 0 && packets[0].fieldType < fieldType(f.Type) {
+			data = appendPacketV2(data, packets[0])
+			packets = packets[1:]
+		}
+		data = appendPacketV2(data, packetV2{
+			fieldType: fieldType(f.Type),
+			data:      f.Data,
+		})
+	}
+	for _, p := range packets {
+		data = appendPacketV2(data, p)
+	}
+	return appendEOSV2(data)
+}
+
+// extraFieldsV2 returns the given packets as extra fields.
+// It returns an error if any of them have a known field type.
+func extraFieldsV2(packets []packetV2) ([]Field, error) {
+	var fields []Field
+	for _, p := range packets {
+		if isKnownFieldTypeV2(p.fieldType) {
+			return nil, fmt.Errorf("unexpected field type %d", p.fieldType)
+		}
+		fields = append(fields, Field{
+			Type: int(p.fieldType),
+			Data: p.data,
+		})
+	}
+	return fields, nil
+}
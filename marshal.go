@@ -36,15 +36,79 @@ func (m *Macaroon) Version() Version {
 	return m.version
 }
 
+// ConvertTo returns a copy of the macaroon that will be marshaled
+// in the format of the given version. The signature of the
+// returned macaroon remains valid, as the encoding format does
+// not affect the signature.
+//
+// Converting to V1 fails if the macaroon's id or any of its caveat
+// ids are not valid UTF-8, or if any of its fields are too long to
+// be represented in the V1 format.
+func (m *Macaroon) ConvertTo(v Version) (*Macaroon, error) {
+	if v < V1 || v > LatestVersion {
+		return nil, fmt.Errorf("invalid version %v", v)
+	}
+	if v < V2 {
+		if err := m.checkV1(); err != nil {
+			return nil, fmt.Errorf("cannot convert to %v: %v", v, err)
+		}
+	}
+	m1 := m.Clone()
+	m1.version = v
+	return m1, nil
+}
+
 // MarshalJSON implements json.Marshaler by marshaling the
 // macaroon in JSON format. The serialisation format is determined
 // by the macaroon's version.
 func (m *Macaroon) MarshalJSON() ([]byte, error) {
+	var e JSONEncoder
+	return e.Marshal(m)
+}
+
+// JSONBinaryEncoding specifies how binary data is
+// encoded in the V2 JSON format.
+type JSONBinaryEncoding int
+
+const (
+	// JSONBase64URL specifies unpadded URL-safe base64 encoding.
+	// This is the default.
+	JSONBase64URL JSONBinaryEncoding = iota
+
+	// JSONBase64Std specifies padded standard base64 encoding.
+	JSONBase64Std
+
+	// JSONHex specifies hexadecimal encoding.
+	JSONHex
+)
+
+// JSONEncoder marshals macaroons in JSON format with configurable
+// encoding of binary fields. The zero value marshals macaroons
+// in the same way as Macaroon.MarshalJSON.
+//
+// The options only affect the V2 JSON format; the V1 format
+// is fixed by libmacaroons.
+type JSONEncoder struct {
+	// BinaryEncoding specifies how identifiers, signatures
+	// and verification ids that are not encoded as text
+	// are encoded.
+	BinaryEncoding JSONBinaryEncoding
+
+	// ForceBinary specifies that all identifiers, signatures
+	// and verification ids are encoded using BinaryEncoding,
+	// even when they are valid UTF-8. By default, valid UTF-8
+	// fields are encoded as plain JSON strings.
+	ForceBinary bool
+}
+
+// Marshal returns the JSON encoding of m. The serialisation format
+// is determined by the macaroon's version.
+func (e *JSONEncoder) Marshal(m *Macaroon) ([]byte, error) {
 	switch m.version {
 	case V1:
 		return m.marshalJSONV1()
 	case V2:
-		return m.marshalJSONV2()
+		return m.marshalJSONV2(e)
 	default:
 		return nil, fmt.Errorf("unknown version %v", m.version)
 	}
@@ -57,42 +121,64 @@ func (m *Macaroon) MarshalJSON() ([]byte, error) {
 //
 // After unmarshaling, the macaroon's version will reflect
 // the version that it was unmarshaled as.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
 func (m *Macaroon) UnmarshalJSON(data []byte) error {
+	return DefaultUnmarshalOptions.UnmarshalMacaroonJSON(m, data)
+}
+
+// unmarshalJSON is the internal version of UnmarshalJSON.
+// The given options are used to enforce limits when
+// data holds a base64-encoded binary macaroon; the caller
+// is responsible for checking the limits otherwise.
+func (m *Macaroon) unmarshalJSON(data []byte, opts *UnmarshalOptions) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty JSON macaroon data")
+	}
 	if data[0] == '"' {
 		// It's a string, so it must be a base64-encoded binary form.
-		var s string
-		if err := json.Unmarshal(data, &s); err != nil {
-			return err
-		}
-		data, err := base64Decode([]byte(s))
+		data, err := unmarshalBase64JSON(data)
 		if err != nil {
 			return err
 		}
-		if err := m.UnmarshalBinary(data); err != nil {
-			return err
-		}
-		return nil
+		return opts.parseMacaroon(m, data)
 	}
 	// Not a string; try to unmarshal into both kinds of macaroon object.
 	// This assumes that neither format has any fields in common.
 	// For subsequent versions we may need to change this approach.
+	if opts.StrictJSON {
+		if err := checkStrictJSON(data); err != nil {
+			return fmt.Errorf("invalid JSON macaroon: %v", err)
+		}
+	}
 	var both struct {
-		*macaroonJSONV1
-		*macaroonJSONV2
+		macaroonJSONV1
+		macaroonJSONV2
 	}
 	if err := json.Unmarshal(data, &both); err != nil {
 		return err
 	}
+	// Check the number of caveats before building them.
+	// The field lengths are checked by the caller.
+	if err := opts.checkCaveatCount(len(both.macaroonJSONV1.Caveats) + len(both.macaroonJSONV2.Caveats)); err != nil {
+		return err
+	}
+	isV1, isV2 := !both.macaroonJSONV1.isZero(), !both.macaroonJSONV2.isZero()
 	switch {
-	case both.macaroonJSONV1 != nil && both.macaroonJSONV2 != nil:
+	case isV1 && isV2:
 		return fmt.Errorf("cannot determine macaroon encoding version")
-	case both.macaroonJSONV1 != nil:
-		if err := m.initJSONV1(both.macaroonJSONV1); err != nil {
+	case isV1:
+		if opts.StrictJSON {
+			if err := checkStrictJSONV1(&both.macaroonJSONV1); err != nil {
+				return fmt.Errorf("invalid JSON macaroon: %v", err)
+			}
+		}
+		if err := m.initJSONV1(&both.macaroonJSONV1); err != nil {
 			return err
 		}
 		m.version = V1
-	case both.macaroonJSONV2 != nil:
-		if err := m.initJSONV2(both.macaroonJSONV2); err != nil {
+	case isV2:
+		if err := m.initJSONV2(&both.macaroonJSONV2); err != nil {
 			return err
 		}
 		m.version = V2
@@ -102,28 +188,42 @@ func (m *Macaroon) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// unmarshalBase64JSON unmarshals the JSON string in data
+// and returns its base64-decoded contents.
+func unmarshalBase64JSON(data []byte) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return base64Decode([]byte(s))
+}
+
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
 // It accepts both V1 and V2 binary encodings.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
 func (m *Macaroon) UnmarshalBinary(data []byte) error {
-	// Copy the data to avoid retaining references to it
-	// in the internal data structures.
-	data = append([]byte(nil), data...)
-	_, err := m.parseBinary(data)
-	return err
+	return DefaultUnmarshalOptions.UnmarshalMacaroonBinary(m, data)
 }
 
 // parseBinary parses the macaroon in binary format
 // from the given data and returns where the parsed data ends.
+// The caveat and field length limits in o are enforced as
+// the data is parsed, so an over-limit macaroon is rejected
+// without parsing all of it.
 //
 // It retains references to data.
-func (m *Macaroon) parseBinary(data []byte) ([]byte, error) {
+func (m *Macaroon) parseBinary(data []byte, o *UnmarshalOptions) ([]byte, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("empty macaroon data")
 	}
 	v := data[0]
 	if v == 2 {
 		// Version 2 binary format.
-		data, err := m.parseBinaryV2(data)
+		data, err := m.parseBinaryV2(data, o)
+		if _, ok := err.(*LimitError); ok {
+			return nil, err
+		}
 		if err != nil {
 			return nil, fmt.Errorf("unmarshal v2: %v", err)
 		}
@@ -132,7 +232,10 @@ func (m *Macaroon) parseBinary(data []byte) ([]byte, error) {
 	}
 	if isASCIIHex(v) {
 		// It's a hex digit - version 1 binary format
-		data, err := m.parseBinaryV1(data)
+		data, err := m.parseBinaryV1(data, o)
+		if _, ok := err.(*LimitError); ok {
+			return nil, err
+		}
 		if err != nil {
 			return nil, fmt.Errorf("unmarshal v1: %v", err)
 		}
@@ -149,6 +252,29 @@ func (m *Macaroon) MarshalBinary() ([]byte, error) {
 	return m.appendBinary(nil)
 }
 
+// MarshalText implements encoding.TextMarshaler by
+// formatting the macaroon as the unpadded URL-safe base64
+// encoding of its binary form.
+func (m *Macaroon) MarshalText() ([]byte, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return base64Encode(data), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// It accepts both standard padded and unpadded URL-safe
+// base64 encodings of any binary encoding accepted
+// by UnmarshalBinary.
+func (m *Macaroon) UnmarshalText(text []byte) error {
+	data, err := base64Decode(text)
+	if err != nil {
+		return fmt.Errorf("cannot decode macaroon: %v", err)
+	}
+	return DefaultUnmarshalOptions.parseMacaroon(m, data)
+}
+
 // appendBinary appends the binary-formatted macaroon to
 // the given data, formatting it according to the macaroon's
 // version.
@@ -172,7 +298,10 @@ type Slice []*Macaroon
 func (s Slice) MarshalBinary() ([]byte, error) {
 	var data []byte
 	var err error
-	for _, m := range s {
+	for i, m := range s {
+		if m == nil {
+			return nil, fmt.Errorf("nil macaroon at index %d", i)
+		}
 		data, err = m.appendBinary(data)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal macaroon %q: %v", m.Id(), err)
@@ -184,21 +313,78 @@ func (s Slice) MarshalBinary() ([]byte, error) {
 // UnmarshalBinary implements encoding.BinaryUnmarshaler.
 // It accepts all known binary encodings for the data - all the
 // embedded macaroons need not be encoded in the same format.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
 func (s *Slice) UnmarshalBinary(data []byte) error {
-	// Prevent the internal data structures from holding onto the
-	// slice by copying it first.
-	data = append([]byte(nil), data...)
-	*s = (*s)[:0]
-	for len(data) > 0 {
-		var m Macaroon
-		rest, err := m.parseBinary(data)
+	return DefaultUnmarshalOptions.UnmarshalSliceBinary(s, data)
+}
+
+// MarshalText implements encoding.TextMarshaler by
+// formatting the slice as the unpadded URL-safe base64
+// encoding of its binary form.
+func (s Slice) MarshalText() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return base64Encode(data), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// It accepts both standard padded and unpadded URL-safe
+// base64 encodings of any binary encoding accepted
+// by UnmarshalBinary.
+func (s *Slice) UnmarshalText(text []byte) error {
+	data, err := base64Decode(text)
+	if err != nil {
+		return fmt.Errorf("cannot decode macaroons: %v", err)
+	}
+	return DefaultUnmarshalOptions.parseSlice(s, data)
+}
+
+// MarshalJSON implements json.Marshaler by marshaling the
+// macaroons as a JSON array. Each macaroon is marshaled
+// in the JSON format determined by its version.
+func (s Slice) MarshalJSON() ([]byte, error) {
+	var e JSONEncoder
+	return e.MarshalSlice(s)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts
+// a JSON array in which each element may be in any form
+// accepted by Macaroon.UnmarshalJSON, so V1 and V2 objects
+// and base64-encoded binary macaroons may be mixed freely.
+// It also accepts a single base64-encoded JSON string
+// holding the binary-marshaled slice.
+//
+// The limits in DefaultUnmarshalOptions are enforced.
+func (s *Slice) UnmarshalJSON(data []byte) error {
+	return DefaultUnmarshalOptions.UnmarshalSliceJSON(s, data)
+}
+
+// MarshalSlice returns the JSON encoding of s as a JSON array.
+// Each macaroon is marshaled as by Marshal.
+func (e *JSONEncoder) MarshalSlice(s Slice) ([]byte, error) {
+	elems := make([]json.RawMessage, len(s))
+	for i, m := range s {
+		if m == nil {
+			return nil, fmt.Errorf("nil macaroon at index %d", i)
+		}
+		data, err := e.Marshal(m)
 		if err != nil {
-			return fmt.Errorf("cannot unmarshal macaroon: %v", err)
+			return nil, fmt.Errorf("failed to marshal macaroon %q: %v", m.Id(), err)
 		}
-		*s = append(*s, &m)
-		data = rest
+		elems[i] = data
 	}
-	return nil
+	return json.Marshal(elems)
+}
+
+// base64Encode returns the unpadded URL-safe
+// base64 encoding of data.
+func base64Encode(data []byte) []byte {
+	buf := make([]byte, base64.RawURLEncoding.EncodedLen(len(data)))
+	base64.RawURLEncoding.Encode(buf, data)
+	return buf
 }
 
 // base64Decode base64-decodes the given data.
@@ -1,6 +1,11 @@
 package macaroon_test
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
@@ -157,3 +162,482 @@ func (*marshalSuite) testSliceRoundTripWithVersion(c *gc.C, vers macaroon.Versio
 
 	c.Assert(b, jc.DeepEquals, marshaledMacs)
 }
+
+func (*marshalSuite) TestConvertTo(c *gc.C) {
+	rootKey := []byte("secret")
+	m := MustNew(rootKey, []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	err = m.AddThirdPartyCaveat([]byte("shared root key"), []byte("3rd party caveat"), "remote.com")
+	c.Assert(err, gc.IsNil)
+
+	m1, err := m.ConvertTo(macaroon.V1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Version(), gc.Equals, macaroon.V1)
+	c.Assert(m.Version(), gc.Equals, macaroon.V2)
+	c.Assert(m1.Signature(), jc.DeepEquals, m.Signature())
+	c.Assert(m1.Caveats(), jc.DeepEquals, m.Caveats())
+
+	data, err := m1.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var um macaroon.Macaroon
+	err = um.UnmarshalBinary(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(um.Version(), gc.Equals, macaroon.V1)
+
+	m2, err := um.ConvertTo(macaroon.V2)
+	c.Assert(err, gc.IsNil)
+	c.Assert(m2.Version(), gc.Equals, macaroon.V2)
+	data, err = m2.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	expectData, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, jc.DeepEquals, expectData)
+
+	// Adding a caveat to the converted macaroon does
+	// not affect the original.
+	err = m1.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	c.Assert(m.Caveats(), gc.HasLen, 2)
+}
+
+var convertToErrorTests = []struct {
+	about       string
+	id          string
+	caveats     []string
+	vers        macaroon.Version
+	expectError string
+}{{
+	about:       "invalid version",
+	id:          "some id",
+	vers:        3,
+	expectError: `invalid version v3`,
+}, {
+	about:       "binary id",
+	id:          "\xff",
+	vers:        macaroon.V1,
+	expectError: `cannot convert to v1: macaroon id is not valid UTF-8`,
+}, {
+	about:       "id too long",
+	id:          strings.Repeat("x", macaroon.MaxPacketV1Len),
+	vers:        macaroon.V1,
+	expectError: `cannot convert to v1: identifier too long for v1 macaroon \(65535 bytes\)`,
+}, {
+	about:       "caveat too long",
+	id:          "some id",
+	caveats:     []string{"ok", strings.Repeat("x", macaroon.MaxPacketV1Len-8)},
+	vers:        macaroon.V1,
+	expectError: `cannot convert to v1: caveat 1: cid too long for v1 macaroon \(65527 bytes\)`,
+}}
+
+func (*marshalSuite) TestConvertToError(c *gc.C) {
+	for i, test := range convertToErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		m := MustNew([]byte("secret"), []byte(test.id), "", macaroon.V2)
+		for _, cav := range test.caveats {
+			err := m.AddFirstPartyCaveat(cav)
+			c.Assert(err, gc.IsNil)
+		}
+		m1, err := m.ConvertTo(test.vers)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+		c.Assert(m1, gc.IsNil)
+	}
+}
+
+var jsonEncoderTests = []struct {
+	about   string
+	encoder macaroon.JSONEncoder
+	expect  string
+}{{
+	about:  "default encoding",
+	expect: `{"c":[{"i":"account = 3735928559"},{"i":"this was how we remind auth of key/pred","v64":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD_w_dedwv4Jjw7UorCREw5rXbRqIKhr","l":"http://auth.mybank/"}],"l":"http://mybank/","i":"we used our other secret key","s64":"0n2y_R8idg5MPa6BN-LY_B32wHQcGK7UuXJWv3jR9Vw"}`,
+}, {
+	about: "standard base64 encoding",
+	encoder: macaroon.JSONEncoder{
+		BinaryEncoding: macaroon.JSONBase64Std,
+	},
+	expect: `{"c":[{"i":"account = 3735928559"},{"i":"this was how we remind auth of key/pred","v64":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD/w/dedwv4Jjw7UorCREw5rXbRqIKhr","l":"http://auth.mybank/"}],"l":"http://mybank/","i":"we used our other secret key","s64":"0n2y/R8idg5MPa6BN+LY/B32wHQcGK7UuXJWv3jR9Vw="}`,
+}, {
+	about: "hex encoding",
+	encoder: macaroon.JSONEncoder{
+		BinaryEncoding: macaroon.JSONHex,
+	},
+	expect: `{"c":[{"i":"account = 3735928559"},{"i":"this was how we remind auth of key/pred","vH":"000000000000000000000000000000000000000000000000d36ec502e05886d1f0279f055fa52554d16d16c1b14074bbb83ff0fdd79dc2fe098f0ed4a2b091130e6b5db46a20a86b","l":"http://auth.mybank/"}],"l":"http://mybank/","i":"we used our other secret key","sH":"d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c"}`,
+}, {
+	about: "forced hex encoding",
+	encoder: macaroon.JSONEncoder{
+		BinaryEncoding: macaroon.JSONHex,
+		ForceBinary:    true,
+	},
+	expect: `{"c":[{"iH":"6163636f756e74203d2033373335393238353539"},{"iH":"746869732077617320686f772077652072656d696e642061757468206f66206b65792f70726564","vH":"000000000000000000000000000000000000000000000000d36ec502e05886d1f0279f055fa52554d16d16c1b14074bbb83ff0fdd79dc2fe098f0ed4a2b091130e6b5db46a20a86b","l":"http://auth.mybank/"}],"l":"http://mybank/","iH":"77652075736564206f7572206f7468657220736563726574206b6579","sH":"d27db2fd1f22760e4c3dae8137e2d8fc1df6c0741c18aed4b97256bf78d1f55c"}`,
+}, {
+	about: "forced base64 URL encoding",
+	encoder: macaroon.JSONEncoder{
+		ForceBinary: true,
+	},
+	expect: `{"c":[{"i64":"YWNjb3VudCA9IDM3MzU5Mjg1NTk"},{"i64":"dGhpcyB3YXMgaG93IHdlIHJlbWluZCBhdXRoIG9mIGtleS9wcmVk","v64":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA027FAuBYhtHwJ58FX6UlVNFtFsGxQHS7uD_w_dedwv4Jjw7UorCREw5rXbRqIKhr","l":"http://auth.mybank/"}],"l":"http://mybank/","i64":"d2UgdXNlZCBvdXIgb3RoZXIgc2VjcmV0IGtleQ","s64":"0n2y_R8idg5MPa6BN-LY_B32wHQcGK7UuXJWv3jR9Vw"}`,
+}}
+
+func (*marshalSuite) TestJSONEncoder(c *gc.C) {
+	var m macaroon.Macaroon
+	err := m.UnmarshalJSON([]byte(jsonEncoderTests[0].expect))
+	c.Assert(err, gc.IsNil)
+	for i, test := range jsonEncoderTests {
+		c.Logf("test %d: %s", i, test.about)
+		data, err := test.encoder.Marshal(&m)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(data), gc.Equals, test.expect)
+
+		var m1 macaroon.Macaroon
+		err = m1.UnmarshalJSON(data)
+		c.Assert(err, gc.IsNil)
+		assertLibMacaroonsMacaroon(c, &m1)
+		c.Assert(m1.Version(), gc.Equals, macaroon.V2)
+	}
+}
+
+func (*marshalSuite) TestJSONEncoderV1(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V1)
+	expect, err := m.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	e := macaroon.JSONEncoder{
+		BinaryEncoding: macaroon.JSONHex,
+		ForceBinary:    true,
+	}
+	data, err := e.Marshal(m)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, string(expect))
+}
+
+func (*marshalSuite) TestSliceJSONRoundTrip(c *gc.C) {
+	rootKey := []byte("secret")
+	m1 := MustNew(rootKey, []byte("some id"), "a location", macaroon.V1)
+	m2 := MustNew(rootKey, []byte("some other id"), "another location", macaroon.V2)
+	err := m1.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	err = m2.AddFirstPartyCaveat("another caveat")
+	c.Assert(err, gc.IsNil)
+	macaroons := macaroon.Slice{m1, m2}
+
+	data, err := json.Marshal(macaroons)
+	c.Assert(err, gc.IsNil)
+	m1data, err := m1.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	m2data, err := m2.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "["+string(m1data)+","+string(m2data)+"]")
+
+	var unmarshaledMacs macaroon.Slice
+	err = json.Unmarshal(data, &unmarshaledMacs)
+	c.Assert(err, gc.IsNil)
+	c.Assert(unmarshaledMacs, jc.DeepEquals, macaroons)
+}
+
+func (*marshalSuite) TestSliceMarshalJSONEmpty(c *gc.C) {
+	data, err := json.Marshal(macaroon.Slice{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "[]")
+}
+
+func (*marshalSuite) TestSliceUnmarshalJSONMixed(c *gc.C) {
+	rootKey := []byte("secret")
+	m1 := MustNew(rootKey, []byte("some id"), "a location", macaroon.V2)
+	m2 := MustNew(rootKey, []byte("some other id"), "another location", macaroon.V1)
+	m3 := MustNew(rootKey, []byte("\xff"), "", macaroon.V2)
+	m1data, err := m1.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	m2data, err := m2.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	m3data, err := m3.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+
+	data := `["` + base64.RawURLEncoding.EncodeToString(m1data) + `",` + string(m2data) + `,` + string(m3data) + `]`
+	var ms macaroon.Slice
+	err = json.Unmarshal([]byte(data), &ms)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms, jc.DeepEquals, macaroon.Slice{m1, m2, m3})
+}
+
+func (*marshalSuite) TestSliceUnmarshalJSONBase64(c *gc.C) {
+	rootKey := []byte("secret")
+	macaroons := macaroon.Slice{
+		MustNew(rootKey, []byte("some id"), "a location", macaroon.V2),
+		MustNew(rootKey, []byte("some other id"), "another location", macaroon.V1),
+	}
+	data, err := macaroons.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	var ms macaroon.Slice
+	err = json.Unmarshal([]byte(`"`+base64.StdEncoding.EncodeToString(data)+`"`), &ms)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms, jc.DeepEquals, macaroons)
+}
+
+var sliceUnmarshalJSONErrorTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	about:       "null element",
+	data:        `[null]`,
+	expectError: `null macaroon at index 0`,
+}, {
+	about:       "invalid element",
+	data:        `[{"i": "hello", "sH": "00"}]`,
+	expectError: `signature has unexpected length 1`,
+}, {
+	about:       "not an array",
+	data:        `{}`,
+	expectError: `json: cannot unmarshal object into Go value of type .*`,
+}, {
+	about:       "invalid base64",
+	data:        `"!!"`,
+	expectError: `illegal base64 data at input byte 0`,
+}}
+
+func (*marshalSuite) TestSliceUnmarshalJSONError(c *gc.C) {
+	for i, test := range sliceUnmarshalJSONErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var ms macaroon.Slice
+		err := json.Unmarshal([]byte(test.data), &ms)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (*marshalSuite) TestJSONEncoderMarshalSlice(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	e := macaroon.JSONEncoder{
+		BinaryEncoding: macaroon.JSONHex,
+		ForceBinary:    true,
+	}
+	data, err := e.MarshalSlice(macaroon.Slice{m, m})
+	c.Assert(err, gc.IsNil)
+	mdata, err := e.Marshal(m)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "["+string(mdata)+","+string(mdata)+"]")
+}
+
+var sliceNilMacaroonTests = []struct {
+	about   string
+	marshal func(s macaroon.Slice) error
+}{{
+	about: "MarshalBinary",
+	marshal: func(s macaroon.Slice) error {
+		_, err := s.MarshalBinary()
+		return err
+	},
+}, {
+	about: "MarshalText",
+	marshal: func(s macaroon.Slice) error {
+		_, err := s.MarshalText()
+		return err
+	},
+}, {
+	about: "MarshalJSON",
+	marshal: func(s macaroon.Slice) error {
+		_, err := s.MarshalJSON()
+		return err
+	},
+}, {
+	about: "JSONEncoder.MarshalSlice",
+	marshal: func(s macaroon.Slice) error {
+		_, err := (&macaroon.JSONEncoder{}).MarshalSlice(s)
+		return err
+	},
+}, {
+	about: "MarshalCBOR",
+	marshal: func(s macaroon.Slice) error {
+		_, err := s.MarshalCBOR()
+		return err
+	},
+}, {
+	about: "EncodeArmor",
+	marshal: func(s macaroon.Slice) error {
+		_, err := macaroon.EncodeArmor(s)
+		return err
+	},
+}, {
+	about: "Digest",
+	marshal: func(s macaroon.Slice) error {
+		_, err := s.Digest()
+		return err
+	},
+}}
+
+func (*marshalSuite) TestSliceNilMacaroon(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "", macaroon.V2)
+	s := macaroon.Slice{m, nil}
+	for i, test := range sliceNilMacaroonTests {
+		c.Logf("test %d: %s", i, test.about)
+		c.Assert(test.marshal(s), gc.ErrorMatches, `nil macaroon at index 1`)
+	}
+}
+
+func (*marshalSuite) TestMacaroonTextRoundTrip(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("some id"), "a location", macaroon.V2)
+	err := m.AddFirstPartyCaveat("a caveat")
+	c.Assert(err, gc.IsNil)
+	text, err := m.MarshalText()
+	c.Assert(err, gc.IsNil)
+	data, err := m.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(text), gc.Equals, base64.RawURLEncoding.EncodeToString(data))
+
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalText(text)
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m1, jc.DeepEquals, m)
+
+	// Standard padded base64 is also accepted.
+	var m2 macaroon.Macaroon
+	err = m2.UnmarshalText([]byte(base64.StdEncoding.EncodeToString(data)))
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m2, jc.DeepEquals, m)
+}
+
+func (*marshalSuite) TestSliceTextRoundTrip(c *gc.C) {
+	rootKey := []byte("secret")
+	macaroons := macaroon.Slice{
+		MustNew(rootKey, []byte("some id"), "a location", macaroon.V1),
+		MustNew(rootKey, []byte("some other id"), "another location", macaroon.V2),
+	}
+	text, err := macaroons.MarshalText()
+	c.Assert(err, gc.IsNil)
+	var ms macaroon.Slice
+	err = ms.UnmarshalText(text)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ms, jc.DeepEquals, macaroons)
+}
+
+func (*marshalSuite) TestUnmarshalTextError(c *gc.C) {
+	var m macaroon.Macaroon
+	err := m.UnmarshalText([]byte("!!"))
+	c.Assert(err, gc.ErrorMatches, `cannot decode macaroon: illegal base64 data at input byte 0`)
+	err = m.UnmarshalText([]byte("AAAA"))
+	c.Assert(err, gc.ErrorMatches, `cannot determine data format of binary-encoded macaroon`)
+
+	var ms macaroon.Slice
+	err = ms.UnmarshalText([]byte("!!"))
+	c.Assert(err, gc.ErrorMatches, `cannot decode macaroons: illegal base64 data at input byte 0`)
+}
+
+func (*marshalSuite) TestTextMarshalingXML(c *gc.C) {
+	type token struct {
+		Macaroon  *macaroon.Macaroon `xml:"macaroon"`
+		Macaroons macaroon.Slice     `xml:"macaroons,attr"`
+	}
+	rootKey := []byte("secret")
+	t0 := token{
+		Macaroon: MustNew(rootKey, []byte("some id"), "a location", macaroon.V2),
+		Macaroons: macaroon.Slice{
+			MustNew(rootKey, []byte("some other id"), "another location", macaroon.V1),
+		},
+	}
+	data, err := xml.Marshal(t0)
+	c.Assert(err, gc.IsNil)
+	var t1 token
+	err = xml.Unmarshal(data, &t1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(t1, jc.DeepEquals, t0)
+}
+
+// unknownFieldsV2 holds a V2 binary macaroon containing fields
+// of types not known to the macaroon package, with
+// a verifying signature for root key "secret".
+var unknownFieldsV2 = "\x02" +
+	"\x01\x03loc" + "\x02\x02id" + "\x03\x02h3" + "\x07\x00" + "\x00" +
+	"\x02\x02c1" + "\x05\x02c5" + "\x00" +
+	"\x01\x03tp1" + "\x02\x03tp2" + "\x03\x02t3" + "\x04\x01v" + "\x09\x02t9" + "\x00" +
+	"\x00"
+
+func (*marshalSuite) TestUnknownFieldsV2(c *gc.C) {
+	m := MustNew([]byte("secret"), []byte("id"), "loc", macaroon.V2)
+	err := m.AddFirstPartyCaveat("c1")
+	c.Assert(err, gc.IsNil)
+	sig := m.Signature()
+	data := unknownFieldsV2 + "\x06\x20" + string(sig)
+
+	var m1 macaroon.Macaroon
+	err = m1.UnmarshalBinary([]byte(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(m1.Location(), gc.Equals, "loc")
+	c.Assert(string(m1.Id()), gc.Equals, "id")
+	c.Assert(m1.Extra(), jc.DeepEquals, []macaroon.Field{{
+		Type: 3,
+		Data: []byte("h3"),
+	}, {
+		Type: 7,
+		Data: []byte{},
+	}})
+	c.Assert(m1.Caveats(), jc.DeepEquals, []macaroon.Caveat{{
+		Id: []byte("c1"),
+		Extra: []macaroon.Field{{
+			Type: 5,
+			Data: []byte("c5"),
+		}},
+	}, {
+		Id:             []byte("tp2"),
+		VerificationId: []byte("v"),
+		Location:       "tp1",
+		Extra: []macaroon.Field{{
+			Type: 3,
+			Data: []byte("t3"),
+		}, {
+			Type: 9,
+			Data: []byte("t9"),
+		}},
+	}})
+
+	// The binary encoding round trips exactly.
+	data1, err := m1.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data1), gc.Equals, data)
+
+	// So does the JSON encoding.
+	jsonData, err := m1.MarshalJSON()
+	c.Assert(err, gc.IsNil)
+	var m2 macaroon.Macaroon
+	err = m2.UnmarshalJSON(jsonData)
+	c.Assert(err, gc.IsNil)
+	c.Assert(&m2, jc.DeepEquals, &m1)
+
+	// The extra fields cannot be represented in V1.
+	_, err = m1.ConvertTo(macaroon.V1)
+	c.Assert(err, gc.ErrorMatches, `cannot convert to v1: macaroon has fields not supported in v1`)
+}
+
+var unknownFieldsV2ErrorTests = []struct {
+	about       string
+	data        string
+	expectError string
+}{{
+	about:       "signature in header",
+	data:        "\x02" + "\x02\x02id" + "\x06\x00" + "\x00" + "\x00",
+	expectError: `unmarshal v2: invalid macaroon header: unexpected field type 6`,
+}, {
+	about:       "signature in caveat",
+	data:        "\x02" + "\x02\x02id" + "\x00" + "\x02\x02c1" + "\x06\x00" + "\x00" + "\x00",
+	expectError: `unmarshal v2: invalid field found in caveat`,
+}, {
+	about:       "no identifier in caveat",
+	data:        "\x02" + "\x02\x02id" + "\x00" + "\x03\x02c3" + "\x00" + "\x00",
+	expectError: `unmarshal v2: no identifier in caveat`,
+}}
+
+func (*marshalSuite) TestUnknownFieldsV2Error(c *gc.C) {
+	for i, test := range unknownFieldsV2ErrorTests {
+		c.Logf("test %d: %s", i, test.about)
+		var m macaroon.Macaroon
+		err := m.UnmarshalBinary([]byte(test.data))
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+	}
+}
+
+func (*marshalSuite) TestUnknownFieldsV2JSONError(c *gc.C) {
+	var m macaroon.Macaroon
+	sig := `"sH":"` + strings.Repeat("00", 32) + `"`
+	err := m.UnmarshalJSON([]byte(`{"i":"id",` + sig + `,"x":[{"t":4,"d":"x"}]}`))
+	c.Assert(err, gc.ErrorMatches, `invalid extra field type 4`)
+	err = m.UnmarshalJSON([]byte(`{"i":"id",` + sig + `,"x":[{"t":5},{"t":3}]}`))
+	c.Assert(err, gc.ErrorMatches, `invalid extra field type 3`)
+}
@@ -16,6 +16,16 @@ const (
 	fieldSignature      fieldType = 6
 )
 
+// isKnownFieldTypeV2 reports whether t is one of the
+// field types understood by this package.
+func isKnownFieldTypeV2(t fieldType) bool {
+	switch t {
+	case fieldEOS, fieldLocation, fieldIdentifier, fieldVerificationId, fieldSignature:
+		return true
+	}
+	return false
+}
+
 type packetV2 struct {
 	// fieldType holds the type of the field.
 	fieldType fieldType
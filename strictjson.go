@@ -0,0 +1,183 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonKeys holds the set of keys allowed in a JSON object when
+// decoding strictly. If the entry for a key is non-nil, the key's
+// value is an array of objects with the given keys.
+type jsonKeys map[string]jsonKeys
+
+var (
+	fieldJSONV2Keys = jsonKeys{
+		"t":   nil,
+		"d":   nil,
+		"dH":  nil,
+		"d64": nil,
+	}
+	caveatJSONV1Keys = jsonKeys{
+		"cid": nil,
+		"vid": nil,
+		"cl":  nil,
+	}
+	caveatJSONV2Keys = jsonKeys{
+		"i":   nil,
+		"iH":  nil,
+		"i64": nil,
+		"v":   nil,
+		"vH":  nil,
+		"v64": nil,
+		"l":   nil,
+		"x":   fieldJSONV2Keys,
+	}
+	macaroonJSONV1Keys = jsonKeys{
+		"caveats":    caveatJSONV1Keys,
+		"location":   nil,
+		"identifier": nil,
+		"signature":  nil,
+	}
+	macaroonJSONV2Keys = jsonKeys{
+		"c":   caveatJSONV2Keys,
+		"l":   nil,
+		"i":   nil,
+		"iH":  nil,
+		"i64": nil,
+		"s":   nil,
+		"sH":  nil,
+		"s64": nil,
+		"x":   fieldJSONV2Keys,
+	}
+)
+
+// checkStrictJSON checks that the JSON object in data contains
+// only fields with exactly the expected names, with no duplicates,
+// and that the fields are all from one of the V1 or V2
+// formats.
+func checkStrictJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("JSON macaroon is not an object")
+	}
+	var v1Key, v2Key string
+	err = checkJSONObject(dec, "", func(key string) (jsonKeys, bool) {
+		if keys, ok := macaroonJSONV1Keys[key]; ok {
+			v1Key = key
+			return keys, true
+		}
+		if keys, ok := macaroonJSONV2Keys[key]; ok {
+			v2Key = key
+			return keys, true
+		}
+		return nil, false
+	})
+	if err != nil {
+		return err
+	}
+	if v1Key != "" && v2Key != "" {
+		return fmt.Errorf("both V1 field %q and V2 field %q found", v1Key, v2Key)
+	}
+	return nil
+}
+
+// checkJSONObject checks the fields of the JSON object being read
+// by dec, the opening delimiter of which has already been read.
+// The lookup function is used to find the keys allowed in
+// any arrays of objects held in the fields; it reports whether
+// the field is allowed at all. The path holds the path to the
+// object, used in error messages.
+func checkJSONObject(dec *json.Decoder, path string, lookup func(key string) (jsonKeys, bool)) error {
+	found := make(map[string]bool)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		keyPath := path + key
+		keys, ok := lookup(key)
+		if !ok {
+			return fmt.Errorf("unknown field %q", keyPath)
+		}
+		if found[key] {
+			return fmt.Errorf("duplicate field %q", keyPath)
+		}
+		found[key] = true
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if keys == nil || tok != json.Delim('[') {
+			// Leave any type errors to be reported
+			// by json.Unmarshal.
+			if err := skipJSONValue(dec, tok); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := 0; dec.More(); i++ {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if tok != json.Delim('{') {
+				if err := skipJSONValue(dec, tok); err != nil {
+					return err
+				}
+				continue
+			}
+			elemPath := fmt.Sprintf("%s[%d].", keyPath, i)
+			err = checkJSONObject(dec, elemPath, func(key string) (jsonKeys, bool) {
+				keys, ok := keys[key]
+				return keys, ok
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// Read the closing bracket.
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	// Read the closing brace.
+	_, err := dec.Token()
+	return err
+}
+
+// skipJSONValue skips the rest of the JSON value
+// being read by dec that starts with the given token.
+func skipJSONValue(dec *json.Decoder, tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// checkStrictJSONV1 checks the fields of a JSON-unmarshaled
+// V1 macaroon that cannot be checked by checkStrictJSON.
+func checkStrictJSONV1(mjson *macaroonJSONV1) error {
+	if strings.ToLower(mjson.Signature) != mjson.Signature {
+		return fmt.Errorf(`field "signature" is not lower case hex`)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package macaroon_test
+
+import (
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/macaroon.v2-unstable"
+)
+
+type strictJSONSuite struct{}
+
+var _ = gc.Suite(&strictJSONSuite{})
+
+var (
+	strictSigHex = strings.Repeat("ab", 32)
+	strictSig64  = "q6urq6urq6urq6urq6urq6urq6urq6urq6urq6urq6s"
+)
+
+var strictJSONTests = []struct {
+	about string
+	data  string
+	// expectError holds the error expected in strict mode.
+	expectError string
+	// expectLaxError holds the error expected otherwise.
+	expectLaxError string
+}{{
+	about: "valid V1",
+	data:  `{"identifier":"id","location":"loc","signature":"` + strictSigHex + `","caveats":[{"cid":"c1"}]}`,
+}, {
+	about: "valid V2",
+	data:  `{"i":"id","l":"loc","s64":"` + strictSig64 + `","c":[{"i":"c1"}]}`,
+}, {
+	about:          "empty input",
+	data:           ``,
+	expectError:    `empty JSON macaroon data`,
+	expectLaxError: `empty JSON macaroon data`,
+}, {
+	about:       "unknown field",
+	data:        `{"i":"id","s64":"` + strictSig64 + `","foo":1}`,
+	expectError: `invalid JSON macaroon: unknown field "foo"`,
+}, {
+	about:       "unknown caveat field",
+	data:        `{"i":"id","s64":"` + strictSig64 + `","c":[{"i":"c1"},{"i":"c2","cid":"x"}]}`,
+	expectError: `invalid JSON macaroon: unknown field "c\[1\].cid"`,
+}, {
+	about:       "unknown extra field field",
+	data:        `{"i":"id","s64":"` + strictSig64 + `","c":[{"i":"c1","x":[{"t":3,"q":{}}]}]}`,
+	expectError: `invalid JSON macaroon: unknown field "c\[0\].x\[0\].q"`,
+}, {
+	about:       "field with wrong case",
+	data:        `{"Identifier":"id","location":"loc","signature":"` + strictSigHex + `"}`,
+	expectError: `invalid JSON macaroon: unknown field "Identifier"`,
+}, {
+	about:       "duplicate field",
+	data:        `{"i":"id","s64":"` + strictSig64 + `","i":"other"}`,
+	expectError: `invalid JSON macaroon: duplicate field "i"`,
+}, {
+	about:       "duplicate caveat field",
+	data:        `{"identifier":"id","signature":"` + strictSigHex + `","caveats":[{"cid":"c1","cid":"c2"}]}`,
+	expectError: `invalid JSON macaroon: duplicate field "caveats\[0\].cid"`,
+}, {
+	about:          "both V1 and V2 fields",
+	data:           `{"identifier":"id","signature":"` + strictSigHex + `","l":"loc"}`,
+	expectError:    `invalid JSON macaroon: both V1 field "signature" and V2 field "l" found`,
+	expectLaxError: `cannot determine macaroon encoding version`,
+}, {
+	about:       "upper case V1 signature",
+	data:        `{"identifier":"id","signature":"` + strings.ToUpper(strictSigHex) + `"}`,
+	expectError: `invalid JSON macaroon: field "signature" is not lower case hex`,
+}}
+
+func (*strictJSONSuite) TestStrictJSON(c *gc.C) {
+	opts := macaroon.DefaultUnmarshalOptions
+	opts.StrictJSON = true
+	for i, test := range strictJSONTests {
+		c.Logf("test %d: %s", i, test.about)
+		var m macaroon.Macaroon
+		err := opts.UnmarshalMacaroonJSON(&m, []byte(test.data))
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+		} else {
+			c.Assert(err, gc.IsNil)
+		}
+
+		var m1 macaroon.Macaroon
+		err = m1.UnmarshalJSON([]byte(test.data))
+		if test.expectLaxError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectLaxError)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		if test.expectError == "" {
+			c.Assert(&m, jc.DeepEquals, &m1)
+		}
+	}
+}
+
+func (*strictJSONSuite) TestStrictJSONSlice(c *gc.C) {
+	opts := macaroon.UnmarshalOptions{
+		StrictJSON: true,
+	}
+	var s macaroon.Slice
+	err := opts.UnmarshalSliceJSON(&s, []byte(`[`+strictJSONTests[0].data+`,`+strictJSONTests[1].data+`]`))
+	c.Assert(err, gc.IsNil)
+	c.Assert(s, gc.HasLen, 2)
+
+	err = opts.UnmarshalSliceJSON(&s, []byte(`[`+strictJSONTests[0].data+`,{"i":"id","I":"id"}]`))
+	c.Assert(err, gc.ErrorMatches, `invalid JSON macaroon: unknown field "I"`)
+}